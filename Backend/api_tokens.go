@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- Personal API Tokens ----------------
+// ------------------------------------------------------------------------------------------------
+
+// apiTokenPrefix distinguishes personal API tokens from JWTs in the Authorization header.
+const apiTokenPrefix = "dcepat_"
+
+// apiTokenScopePermissions restricts scoped tokens to a subset of permissions (further
+// limited by the owner's role). The empty scope grants everything the owning user may do.
+var apiTokenScopePermissions = map[string][]string{
+	"decode":    {permDecode},
+	"elf-write": {permElvesRead, permElvesWrite},
+}
+
+func validAPITokenScope(scope string) bool {
+	if scope == "" {
+		return true
+	}
+	_, ok := apiTokenScopePermissions[scope]
+	return ok
+}
+
+func apiTokenScopeAllows(scope, permission string) bool {
+	if scope == "" {
+		return true
+	}
+	for _, p := range apiTokenScopePermissions[scope] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticateAPIToken resolves a presented API token to its owner. Revoked, expired and
+// orphaned tokens (owner deleted or disabled) are rejected. last_used_at is bumped on success.
+func authenticateAPIToken(token string) (*authPrincipal, error) {
+	var p authPrincipal
+	var expiresAt, revokedAt sql.NullTime
+	var disabled bool
+	err := db.QueryRow(`
+		SELECT t.id, t.scope, t.expires_at, t.revoked_at, u.username, u.role, u.must_change_password, u.disabled
+		FROM api_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ?`, hashAPIToken(token)).
+		Scan(&p.APITokenID, &p.Scope, &expiresAt, &revokedAt, &p.Username, &p.Role, &p.MustChangePassword, &disabled)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if disabled {
+		return nil, fmt.Errorf("user disabled")
+	}
+	if revokedAt.Valid {
+		return nil, fmt.Errorf("token revoked")
+	}
+	if expiresAt.Valid && time.Now().After(expiresAt.Time) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if _, err := db.Exec("UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", p.APITokenID); err != nil {
+		log.Printf("Failed to update last_used_at for API token %s: %v", p.APITokenID, err)
+	}
+	return &p, nil
+}
+
+// apiTokensHandler lets a user manage their own API tokens.
+// GET lists, POST creates (returns the secret once), DELETE ?id=<id> revokes.
+func apiTokensHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	principal := principalFromRequest(r)
+	if principal.APITokenID != "" {
+		writeJSONError(w, "API tokens cannot be managed with an API token; log in first.", http.StatusForbidden)
+		return
+	}
+	var userID string
+	if err := db.QueryRow("SELECT id FROM users WHERE username = ?", principal.Username).Scan(&userID); err != nil {
+		writeJSONError(w, "Unauthorized.", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := db.Query(`
+			SELECT id, name, scope, created_at, last_used_at, expires_at, revoked_at
+			FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC`, userID)
+		if err != nil {
+			writeJSONError(w, "Failed to fetch API tokens.", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		list := make([]APIToken, 0, 16)
+		for rows.Next() {
+			var t APIToken
+			var lastUsed, expires, revoked sql.NullTime
+			if err := rows.Scan(&t.ID, &t.Name, &t.Scope, &t.CreatedAt, &lastUsed, &expires, &revoked); err != nil {
+				writeJSONError(w, "Failed to read API tokens.", http.StatusInternalServerError)
+				return
+			}
+			t.LastUsedAt = nullTimePtr(lastUsed)
+			t.ExpiresAt = nullTimePtr(expires)
+			t.RevokedAt = nullTimePtr(revoked)
+			list = append(list, t)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "tokens": list})
+		return
+	case http.MethodPost:
+		var req NewAPITokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, "Invalid request body.", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		setAuditTarget(r, "name="+req.Name)
+		if req.Name == "" {
+			writeJSONError(w, "Token name cannot be empty.", http.StatusBadRequest)
+			return
+		}
+		if !validAPITokenScope(req.Scope) {
+			writeJSONError(w, fmt.Sprintf("Unknown scope %q (expected \"\", \"decode\" or \"elf-write\").", req.Scope), http.StatusBadRequest)
+			return
+		}
+		if req.ExpiresInDays < 0 {
+			writeJSONError(w, "expiresInDays cannot be negative.", http.StatusBadRequest)
+			return
+		}
+		id, err := randomIDHex(12)
+		if err != nil {
+			writeJSONError(w, "Internal server error: id generation.", http.StatusInternalServerError)
+			return
+		}
+		secret, err := randomIDHex(32)
+		if err != nil {
+			writeJSONError(w, "Internal server error: token generation.", http.StatusInternalServerError)
+			return
+		}
+		token := apiTokenPrefix + secret
+		var expiresAt *time.Time
+		if req.ExpiresInDays > 0 {
+			t := time.Now().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+			expiresAt = &t
+		}
+		if _, err := db.Exec(`
+			INSERT INTO api_tokens (id, user_id, name, token_hash, scope, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			id, userID, req.Name, hashAPIToken(token), req.Scope, expiresAt); err != nil {
+			writeJSONError(w, "Failed to create API token.", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": "API token created. Copy it now; it will not be shown again.",
+			"token":   token,
+			"apiToken": APIToken{
+				ID:        id,
+				Name:      req.Name,
+				Scope:     req.Scope,
+				CreatedAt: time.Now(),
+				ExpiresAt: expiresAt,
+			},
+		})
+		return
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			writeJSONError(w, "Missing token id.", http.StatusBadRequest)
+			return
+		}
+		res, err := db.Exec(`
+			UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP
+			WHERE id = ? AND user_id = ? AND revoked_at IS NULL`, id, userID)
+		if err != nil {
+			writeJSONError(w, "Failed to revoke API token.", http.StatusInternalServerError)
+			return
+		}
+		aff, _ := res.RowsAffected()
+		if aff == 0 {
+			writeJSONError(w, "API token not found.", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(ResponsePayload{Message: "API token revoked.", Success: true})
+		return
+	default:
+		writeJSONError(w, "Only GET, POST and DELETE requests are supported.", http.StatusMethodNotAllowed)
+	}
+}
+
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- Audit log ----------------
+// ------------------------------------------------------------------------------------------------
+
+// Every mutating request (anything but GET/HEAD) on a route wrapped with withAudit appends a
+// row to audit_events once the handler has answered. Rows are never updated or deleted by the
+// service; the table additionally carries triggers rejecting UPDATE/DELETE where possible.
+
+// routeActions maps an HTTP method to the audit action name; the "" entry applies to every
+// mutating method not listed explicitly (same convention as routePermissions).
+type routeActions map[string]string
+
+// action names every mutating method of a route the same.
+func action(a string) routeActions {
+	return routeActions{"": a}
+}
+
+func (ra routeActions) forMethod(method string) string {
+	if a, ok := ra[method]; ok {
+		return a
+	}
+	return ra[""]
+}
+
+// auditRecord is filled while the request is handled and written afterwards.
+type auditRecord struct {
+	Actor  string
+	Target string
+}
+
+type auditContextKey struct{}
+
+func auditRecordFrom(r *http.Request) *auditRecord {
+	rec, _ := r.Context().Value(auditContextKey{}).(*auditRecord)
+	return rec
+}
+
+// setAuditActor records who acted for routes without an authenticated principal (e.g. login).
+func setAuditActor(r *http.Request, actor string) {
+	if rec := auditRecordFrom(r); rec != nil {
+		rec.Actor = actor
+	}
+}
+
+// setAuditTarget names the object acted on when it is not in the query string (e.g. a JSON body).
+func setAuditTarget(r *http.Request, target string) {
+	if rec := auditRecordFrom(r); rec != nil {
+		rec.Target = target
+	}
+}
+
+// statusRecorder captures the response status for the audit result.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush keeps SSE responses working through the recorder.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// withAudit records mutating requests. It wraps the permission check so denied attempts
+// are recorded too; requireAuth fills in the actor.
+func withAudit(actions routeActions, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		rec := &auditRecord{Target: r.URL.RawQuery}
+		sr := &statusRecorder{ResponseWriter: w}
+		next(sr, r.WithContext(context.WithValue(r.Context(), auditContextKey{}, rec)))
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		writeAuditEvent(rec.Actor, actions.forMethod(r.Method), rec.Target, clientIP(r), auditResult(sr.status), sr.status)
+	}
+}
+
+func auditResult(status int) string {
+	switch {
+	case status < 400:
+		return "success"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "denied"
+	default:
+		return "failure"
+	}
+}
+
+func writeAuditEvent(actor, action, target, ip, result string, status int) {
+	if len(target) > 255 {
+		target = target[:255]
+	}
+	if _, err := db.Exec(`
+		INSERT INTO audit_events (actor, action, target, client_ip, result, status)
+		VALUES (?, ?, ?, ?, ?, ?)`, actor, action, target, ip, result, status); err != nil {
+		log.Printf("Failed to write audit event (%s %s by %s): %v", action, target, actor, err)
+	}
+}
+
+// AuditEvent is one row of audit_events.
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	ClientIP  string    `json:"clientIp"`
+	Result    string    `json:"result"`
+	Status    int       `json:"status"`
+}
+
+// adminAuditHandler queries the audit log, newest first.
+// GET ?actor=&action=&target=&result=&from=&to= (RFC 3339) &limit=<n>&before=<id>
+// format=csv|ndjson (or the matching Accept header) streams every matching row instead of a page.
+func adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Only GET requests are supported.", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	where := []string{}
+	args := []interface{}{}
+	for _, f := range []struct{ param, column string }{
+		{"actor", "actor"}, {"action", "action"}, {"target", "target"}, {"result", "result"},
+	} {
+		if v := q.Get(f.param); v != "" {
+			where = append(where, f.column+" = ?")
+			args = append(args, v)
+		}
+	}
+	for _, f := range []struct{ param, op string }{{"from", ">="}, {"to", "<"}} {
+		if v := q.Get(f.param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeJSONError(w, f.param+" must be an RFC 3339 timestamp.", http.StatusBadRequest)
+				return
+			}
+			where = append(where, "created_at "+f.op+" ?")
+			args = append(args, t)
+		}
+	}
+	if v := q.Get("before"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeJSONError(w, "before must be an event id.", http.StatusBadRequest)
+			return
+		}
+		where = append(where, "id < ?")
+		args = append(args, id)
+	}
+
+	format := q.Get("format")
+	if format == "" {
+		switch accept := r.Header.Get("Accept"); {
+		case strings.Contains(accept, "text/csv"):
+			format = "csv"
+		case strings.Contains(accept, "application/x-ndjson"):
+			format = "ndjson"
+		}
+	}
+	if format != "" && format != "json" && format != "csv" && format != "ndjson" {
+		writeJSONError(w, "format must be json, csv or ndjson.", http.StatusBadRequest)
+		return
+	}
+	export := format == "csv" || format == "ndjson"
+
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSONError(w, "limit must be a positive integer.", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if !export && limit > 1000 {
+		limit = 1000
+	}
+
+	query := "SELECT id, created_at, actor, action, target, client_ip, result, status FROM audit_events"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	// Exports are unbounded unless a limit is given explicitly
+	if !export || q.Get("limit") != "" {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := db.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		writeJSONError(w, "Failed to query audit log.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	next := func() (AuditEvent, error) {
+		var e AuditEvent
+		err := rows.Scan(&e.ID, &e.CreatedAt, &e.Actor, &e.Action, &e.Target, &e.ClientIP, &e.Result, &e.Status)
+		return e, err
+	}
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit-events.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "createdAt", "actor", "action", "target", "clientIp", "result", "status"})
+		for rows.Next() {
+			e, err := next()
+			if err != nil {
+				log.Printf("Audit export aborted: %v", err)
+				break
+			}
+			cw.Write([]string{strconv.FormatInt(e.ID, 10), e.CreatedAt.UTC().Format(time.RFC3339), e.Actor, e.Action, e.Target, e.ClientIP, e.Result, strconv.Itoa(e.Status)})
+		}
+		cw.Flush()
+		return
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit-events.ndjson"`)
+		enc := json.NewEncoder(w)
+		for rows.Next() {
+			e, err := next()
+			if err != nil {
+				log.Printf("Audit export aborted: %v", err)
+				break
+			}
+			enc.Encode(e)
+		}
+		return
+	}
+
+	events := make([]AuditEvent, 0, limit)
+	for rows.Next() {
+		e, err := next()
+		if err != nil {
+			writeJSONError(w, "Failed to read audit log.", http.StatusInternalServerError)
+			return
+		}
+		events = append(events, e)
+	}
+	resp := map[string]interface{}{"success": true, "events": events}
+	// Keyset pagination: pass nextBefore as ?before= to get the following page
+	if len(events) == limit {
+		resp["nextBefore"] = events[len(events)-1].ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
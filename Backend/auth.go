@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
-	"time"
+	"strings"
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -13,12 +15,7 @@ import (
 // ------------------------------------------------------------------------------------------------
 
 func parseAndValidateToken(tokenStr string) (jwt.MapClaims, error) {
-	tok, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method")
-		}
-		return getJWTSecret(), nil
-	})
+	tok, err := jwt.Parse(tokenStr, verificationKey)
 	if err != nil || !tok.Valid {
 		return nil, fmt.Errorf("invalid token")
 	}
@@ -26,47 +23,78 @@ func parseAndValidateToken(tokenStr string) (jwt.MapClaims, error) {
 	if !ok {
 		return nil, fmt.Errorf("invalid claims")
 	}
+	if err := checkTokenRevocation(claims); err != nil {
+		return nil, err
+	}
 	return claims, nil
 }
 
-func withAuth(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		auth := r.Header.Get("Authorization")
-		if len(auth) < 8 || auth[:7] != "Bearer " {
-			writeJSONError(w, "Unauthorized.", http.StatusUnauthorized)
-			return
-		}
-		tokenStr := auth[7:]
-		if _, err := parseAndValidateToken(tokenStr); err != nil {
-			writeJSONError(w, "Unauthorized.", http.StatusUnauthorized)
-			return
-		}
-		next(w, r)
+// authPrincipal identifies the caller of an authenticated request.
+type authPrincipal struct {
+	Username   string
+	Role       string
+	APITokenID string // set when authenticated with a personal API token
+	Scope      string // API token scope ("" = full access)
+	// MustChangePassword restricts the caller to passwordChangePath until the password is changed
+	MustChangePassword bool
+}
+
+type principalContextKey struct{}
+
+// principalFromRequest returns the caller set by withAuth/withPermission (zero value if none).
+func principalFromRequest(r *http.Request) authPrincipal {
+	p, _ := r.Context().Value(principalContextKey{}).(authPrincipal)
+	return p
+}
+
+// authenticateRequest accepts either a JWT from loginHandler or a personal API token.
+func authenticateRequest(r *http.Request) (*authPrincipal, error) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 8 || auth[:7] != "Bearer " {
+		return nil, fmt.Errorf("missing bearer token")
+	}
+	tokenStr := auth[7:]
+	if strings.HasPrefix(tokenStr, apiTokenPrefix) {
+		return authenticateAPIToken(tokenStr)
 	}
+	claims, err := parseAndValidateToken(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	sub, _ := claims["sub"].(string)
+	role, _ := claims["role"].(string)
+	mustChange, _ := claims["mcp"].(bool)
+	return &authPrincipal{Username: sub, Role: role, MustChangePassword: mustChange}, nil
 }
 
-func withAdmin(next http.HandlerFunc) http.HandlerFunc {
+// requireAuth authenticates the request and stores the caller in the request context.
+// Scoped API tokens are only accepted when allowScoped is set, i.e. on routes guarded by
+// withPermission, where the scope is checked against the required permission.
+func requireAuth(allowScoped bool, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth := r.Header.Get("Authorization")
-		if len(auth) < 8 || auth[:7] != "Bearer " {
+		principal, err := authenticateRequest(r)
+		if err != nil {
 			writeJSONError(w, "Unauthorized.", http.StatusUnauthorized)
 			return
 		}
-		tokenStr := auth[7:]
-		claims, err := parseAndValidateToken(tokenStr)
-		if err != nil {
-			writeJSONError(w, "Unauthorized.", http.StatusUnauthorized)
+		setAuditActor(r, principal.Username)
+		if principal.MustChangePassword && r.URL.Path != passwordChangePath {
+			writeJSONError(w, "Password change required before using this endpoint.", http.StatusForbidden)
 			return
 		}
-		role, _ := claims["role"].(string)
-		if role != "admin" {
-			writeJSONError(w, "Forbidden.", http.StatusForbidden)
+		if principal.Scope != "" && !allowScoped {
+			writeJSONError(w, "Forbidden: API token scope does not allow this endpoint.", http.StatusForbidden)
 			return
 		}
-		next(w, r)
+		next(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, *principal)))
 	}
 }
 
+// withAuth requires any authenticated caller (no specific permission).
+func withAuth(next http.HandlerFunc) http.HandlerFunc {
+	return requireAuth(false, next)
+}
+
 // loginHandler handles user authentication.
 func loginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -86,36 +114,44 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var storedPassword, role string
-	err := db.QueryRow("SELECT password, role FROM users WHERE username = ?", creds.Username).Scan(&storedPassword, &role)
-	if err != nil || storedPassword != creds.Password {
+	setAuditActor(r, creds.Username)
+	ip := clientIP(r)
+	if wait, err := loginBlockedFor(creds.Username, ip); err != nil {
+		log.Printf("Login throttle lookup failed: %v", err)
+		writeJSONError(w, "Internal server error: login throttle.", http.StatusInternalServerError)
+		return
+	} else if wait > 0 {
+		recordLoginAttempt(r, creds.Username, loginResultLocked)
+		writeLoginBlocked(w, wait)
+		return
+	}
+
+	res, err := authenticate(r.Context(), creds.Username, creds.Password)
+	if err != nil {
+		recordLoginAttempt(r, creds.Username, loginResultInvalid)
+		if err := recordLoginFailure(throttleKindUser, creds.Username, loginMaxFailures); err != nil {
+			log.Printf("Failed to record login failure for %s: %v", creds.Username, err)
+		}
+		if err := recordLoginFailure(throttleKindIP, ip, loginIPMaxFailures); err != nil {
+			log.Printf("Failed to record login failure for %s: %v", ip, err)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(LoginResponse{Message: "Invalid username or password.", Success: false})
 		return
 	}
+	recordLoginAttempt(r, creds.Username, loginResultSuccess)
+	clearLoginFailures(creds.Username)
 
-	// Issue JWT token
-	claims := jwt.MapClaims{
-		"sub":  creds.Username,
-		"role": role,
-		"iss":  "dce-fw-service",
-		"exp":  jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-		"iat":  jwt.NewNumericDate(time.Now()),
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed, err := token.SignedString(getJWTSecret())
+	// Issue access + refresh tokens
+	resp, err := issueSession(res.UserID, res.Username, res.Role, res.TokenVersion, "")
 	if err != nil {
+		log.Printf("Failed to issue session for %s: %v", creds.Username, err)
 		writeJSONError(w, "Failed to issue token.", http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(LoginResponse{
-		Message: "Authentication successful.",
-		Success: true,
-		Role:    role,
-		Token:   signed,
-	})
+	json.NewEncoder(w).Encode(resp)
 }
 
 
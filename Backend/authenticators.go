@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- Pluggable password authenticators ----------------
+// ------------------------------------------------------------------------------------------------
+
+// errInvalidCredentials means the backend did not accept the credentials; the next
+// configured authenticator (if any) is tried.
+var errInvalidCredentials = errors.New("invalid username or password")
+
+// authResult identifies the local users row a successful login maps to.
+type authResult struct {
+	UserID       string
+	Username     string
+	Role         string
+	TokenVersion int
+}
+
+// Authenticator verifies a username/password pair for loginHandler.
+type Authenticator interface {
+	Name() string
+	Authenticate(ctx context.Context, username, password string) (*authResult, error)
+}
+
+// authenticators is the ordered chain from AUTH_BACKENDS (comma-separated: "local", "ldap").
+var authenticators = buildAuthenticators(getenv("AUTH_BACKENDS", "local"))
+
+func buildAuthenticators(spec string) []Authenticator {
+	var chain []Authenticator
+	for _, name := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(name) {
+		case "local":
+			chain = append(chain, dbAuthenticator{})
+		case "ldap":
+			chain = append(chain, newLDAPAuthenticatorFromEnv())
+		case "":
+		default:
+			log.Printf("AUTH_BACKENDS: ignoring unknown backend %q", name)
+		}
+	}
+	if len(chain) == 0 {
+		chain = append(chain, dbAuthenticator{})
+	}
+	return chain
+}
+
+// authenticate tries each configured authenticator in order.
+func authenticate(ctx context.Context, username, password string) (*authResult, error) {
+	if username == "" || password == "" {
+		return nil, errInvalidCredentials
+	}
+	for _, a := range authenticators {
+		res, err := a.Authenticate(ctx, username, password)
+		if err == nil {
+			return res, nil
+		}
+		if !errors.Is(err, errInvalidCredentials) {
+			log.Printf("Authenticator %s failed for %s: %v", a.Name(), username, err)
+		}
+	}
+	return nil, errInvalidCredentials
+}
+
+// dbAuthenticator checks the password hash stored in the users table.
+type dbAuthenticator struct{}
+
+func (dbAuthenticator) Name() string { return "local" }
+
+func (dbAuthenticator) Authenticate(ctx context.Context, username, password string) (*authResult, error) {
+	res := authResult{Username: username}
+	var storedPassword string
+	var disabled bool
+	err := db.QueryRowContext(ctx, "SELECT id, password, role, token_version, disabled FROM users WHERE username = ?", username).
+		Scan(&res.UserID, &storedPassword, &res.Role, &res.TokenVersion, &disabled)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		// Burn a comparable amount of time so unknown usernames are not distinguishable
+		storedPassword = dummyPasswordHash
+	}
+	ok, needsRehash := verifyPassword(storedPassword, password)
+	if err != nil || !ok || disabled {
+		return nil, errInvalidCredentials
+	}
+	if needsRehash {
+		if err := upgradePasswordHash(username, storedPassword, password); err != nil {
+			log.Printf("Failed to upgrade password hash for %s: %v", username, err)
+		} else {
+			log.Printf("Upgraded stored password hash for %s.", username)
+		}
+	}
+	return &res, nil
+}
+
+// ldapAuthenticator performs a simple bind as the user (found via search or a DN template)
+// and derives the role from group membership.
+type ldapAuthenticator struct {
+	URL            string
+	StartTLS       bool
+	SkipVerify     bool
+	BindDN         string // service account for searches (optional with UserDNTemplate)
+	BindPassword   string
+	UserBaseDN     string
+	UserFilter     string // e.g. (uid=%s) or (sAMAccountName=%s)
+	UserDNTemplate string // e.g. uid=%s,ou=people,dc=example,dc=com (skips the user search)
+	GroupBaseDN    string
+	GroupFilter    string   // %s is replaced with the escaped user DN
+	AdminGroupDNs  []string // membership grants "admin"
+	UserGroupDNs   []string // when set, membership is required to log in at all
+}
+
+func newLDAPAuthenticatorFromEnv() *ldapAuthenticator {
+	return &ldapAuthenticator{
+		URL:            getenv("LDAP_URL", "ldap://localhost:389"),
+		StartTLS:       getenv("LDAP_START_TLS", "false") == "true",
+		SkipVerify:     getenv("LDAP_INSECURE_SKIP_VERIFY", "false") == "true",
+		BindDN:         getenv("LDAP_BIND_DN", ""),
+		BindPassword:   getenv("LDAP_BIND_PASSWORD", ""),
+		UserBaseDN:     getenv("LDAP_USER_BASE_DN", ""),
+		UserFilter:     getenv("LDAP_USER_FILTER", "(uid=%s)"),
+		UserDNTemplate: getenv("LDAP_USER_DN_TEMPLATE", ""),
+		GroupBaseDN:    getenv("LDAP_GROUP_BASE_DN", ""),
+		GroupFilter:    getenv("LDAP_GROUP_FILTER", "(|(member=%s)(uniqueMember=%s))"),
+		// DNs contain commas, so the lists are separated by semicolons
+		AdminGroupDNs: splitDNList(getenv("LDAP_ADMIN_GROUP_DNS", "")),
+		UserGroupDNs:  splitDNList(getenv("LDAP_USER_GROUP_DNS", "")),
+	}
+}
+
+func splitDNList(v string) []string {
+	var out []string
+	for _, dn := range strings.Split(v, ";") {
+		if dn = strings.TrimSpace(dn); dn != "" {
+			out = append(out, dn)
+		}
+	}
+	return out
+}
+
+func (a *ldapAuthenticator) Name() string { return "ldap" }
+
+func (a *ldapAuthenticator) dial() (*ldap.Conn, error) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: a.SkipVerify}
+	conn, err := ldap.DialURL(a.URL, ldap.DialWithTLSConfig(tlsCfg))
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", a.URL, err)
+	}
+	if a.StartTLS {
+		if err := conn.StartTLS(tlsCfg); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("starttls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (a *ldapAuthenticator) Authenticate(ctx context.Context, username, password string) (*authResult, error) {
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	userDN, err := a.findUserDN(conn, username)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Bind(userDN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errInvalidCredentials
+		}
+		return nil, fmt.Errorf("user bind: %w", err)
+	}
+
+	// Group search runs as the service account when one is configured
+	if a.BindDN != "" {
+		if err := conn.Bind(a.BindDN, a.BindPassword); err != nil {
+			return nil, fmt.Errorf("service bind: %w", err)
+		}
+	}
+	groups, err := a.userGroups(conn, userDN)
+	if err != nil {
+		return nil, err
+	}
+	role, mapped := "", true
+	switch {
+	case containsDN(groups, a.AdminGroupDNs):
+		role = "admin"
+	case len(a.UserGroupDNs) == 0:
+		// no group grants "user", it is only the default for new accounts
+		role, mapped = "user", false
+	case containsDN(groups, a.UserGroupDNs):
+		role = "user"
+	default:
+		log.Printf("LDAP user %s is not a member of any allowed group", username)
+		return nil, errInvalidCredentials
+	}
+
+	userID, role, tokenVersion, err := provisionExternalUser("ldap", username, role, mapped)
+	if err != nil {
+		return nil, err
+	}
+	return &authResult{UserID: userID, Username: username, Role: role, TokenVersion: tokenVersion}, nil
+}
+
+func (a *ldapAuthenticator) findUserDN(conn *ldap.Conn, username string) (string, error) {
+	if a.UserDNTemplate != "" {
+		return fmt.Sprintf(a.UserDNTemplate, ldap.EscapeDN(username)), nil
+	}
+	if a.BindDN != "" {
+		if err := conn.Bind(a.BindDN, a.BindPassword); err != nil {
+			return "", fmt.Errorf("service bind: %w", err)
+		}
+	}
+	filter := strings.ReplaceAll(a.UserFilter, "%s", ldap.EscapeFilter(username))
+	res, err := conn.Search(ldap.NewSearchRequest(
+		a.UserBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 10, false,
+		filter, []string{"dn"}, nil))
+	if err != nil {
+		return "", fmt.Errorf("user search: %w", err)
+	}
+	if len(res.Entries) != 1 {
+		return "", errInvalidCredentials
+	}
+	return res.Entries[0].DN, nil
+}
+
+func (a *ldapAuthenticator) userGroups(conn *ldap.Conn, userDN string) ([]string, error) {
+	if a.GroupBaseDN == "" {
+		return nil, nil
+	}
+	filter := strings.ReplaceAll(a.GroupFilter, "%s", ldap.EscapeFilter(userDN))
+	res, err := conn.Search(ldap.NewSearchRequest(
+		a.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 10, false,
+		filter, []string{"dn"}, nil))
+	if err != nil {
+		return nil, fmt.Errorf("group search: %w", err)
+	}
+	groups := make([]string, 0, len(res.Entries))
+	for _, e := range res.Entries {
+		groups = append(groups, e.DN)
+	}
+	return groups, nil
+}
+
+// containsDN reports whether any of have matches one of want (DN comparison is case-insensitive).
+func containsDN(have, want []string) bool {
+	for _, h := range have {
+		hp, err := ldap.ParseDN(h)
+		for _, w := range want {
+			if wp, err2 := ldap.ParseDN(w); err == nil && err2 == nil {
+				if hp.EqualFold(wp) {
+					return true
+				}
+			} else if strings.EqualFold(h, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// provisionExternalUser creates the local users row for an externally authenticated account
+// on first login and returns the account's role. mapped reports whether a configured group
+// granted role; only then is an existing account's role overwritten, so roles assigned in the
+// admin UI (e.g. auditor) survive logins. "admin" is the exception: it is taken away again
+// (to role) once no admin group grants it. Accounts from another source (e.g. a local password
+// account with the same username) are never taken over.
+func provisionExternalUser(source, username, role string, mapped bool) (string, string, int, error) {
+	var id, existingSource, existingRole string
+	var tokenVersion int
+	var disabled bool
+	err := db.QueryRow("SELECT id, auth_source, role, token_version, disabled FROM users WHERE username = ?", username).Scan(&id, &existingSource, &existingRole, &tokenVersion, &disabled)
+	switch {
+	case err == sql.ErrNoRows:
+		id, err = randomIDHex(12)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("id generation failed")
+		}
+		if _, err := db.Exec("INSERT INTO users (id, username, password, role, auth_source) VALUES (?, ?, ?, ?, ?)",
+			id, username, unusablePassword, role, source); err != nil {
+			return "", "", 0, fmt.Errorf("failed to provision user")
+		}
+		log.Printf("Provisioned %s user '%s' with role '%s'.", source, username, role)
+		return id, role, 0, nil
+	case err != nil:
+		return "", "", 0, fmt.Errorf("database failure")
+	case existingSource != source:
+		return "", "", 0, fmt.Errorf("an account named %q already exists from source %q", username, existingSource)
+	case disabled:
+		return "", "", 0, fmt.Errorf("account %q is disabled", username)
+	}
+	if role == existingRole || (!mapped && existingRole != "admin") {
+		return id, existingRole, tokenVersion, nil
+	}
+	if _, err := db.Exec("UPDATE users SET role = ? WHERE id = ?", role, id); err != nil {
+		return "", "", 0, fmt.Errorf("failed to update user role")
+	}
+	log.Printf("Role of %s user '%s' changed from '%s' to '%s' by group mapping.", source, username, existingRole, role)
+	return id, role, tokenVersion, nil
+}
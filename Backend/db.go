@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
 	_ "github.com/go-sql-driver/mysql"
 )
 
@@ -57,6 +58,159 @@ func initDB() error {
 		return fmt.Errorf("create build_elves: %w", err)
 	}
 
+	// Personal API tokens (only the SHA-256 of the secret is stored)
+	apiTokensSQL := `
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id VARCHAR(64) PRIMARY KEY,
+		user_id VARCHAR(64) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		scope VARCHAR(32) NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMP NULL DEFAULT NULL,
+		expires_at TIMESTAMP NULL DEFAULT NULL,
+		revoked_at TIMESTAMP NULL DEFAULT NULL,
+		INDEX idx_api_tokens_user (user_id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+	if _, err := db.Exec(apiTokensSQL); err != nil {
+		return fmt.Errorf("create api_tokens: %w", err)
+	}
+
+	// token_version is bumped to invalidate every outstanding access token of a user
+	if _, err := db.Exec("ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INT NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("alter users token_version: %w", err)
+	}
+
+	// auth_source records where an account authenticates: "local" (password), "oidc" or "ldap"
+	if _, err := db.Exec("ALTER TABLE users ADD COLUMN IF NOT EXISTS auth_source VARCHAR(16) NOT NULL DEFAULT 'local'"); err != nil {
+		return fmt.Errorf("alter users auth_source: %w", err)
+	}
+
+	// must_change_password forces a password change before any other API use
+	if _, err := db.Exec("ALTER TABLE users ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return fmt.Errorf("alter users must_change_password: %w", err)
+	}
+
+	// disabled accounts cannot log in and their tokens are rejected
+	if _, err := db.Exec("ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return fmt.Errorf("alter users disabled: %w", err)
+	}
+
+	// Rotating refresh tokens (hashed) grouped by login family
+	refreshTokensSQL := `
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id VARCHAR(64) PRIMARY KEY,
+		user_id VARCHAR(64) NOT NULL,
+		family_id VARCHAR(64) NOT NULL,
+		token_hash CHAR(64) NOT NULL UNIQUE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP NULL DEFAULT NULL,
+		INDEX idx_refresh_tokens_user (user_id),
+		INDEX idx_refresh_tokens_family (family_id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+	if _, err := db.Exec(refreshTokensSQL); err != nil {
+		return fmt.Errorf("create refresh_tokens: %w", err)
+	}
+
+	// Access tokens revoked by logout, kept until they would have expired anyway
+	revokedTokensSQL := `
+	CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti VARCHAR(64) PRIMARY KEY,
+		expires_at TIMESTAMP NOT NULL
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+	if _, err := db.Exec(revokedTokensSQL); err != nil {
+		return fmt.Errorf("create revoked_tokens: %w", err)
+	}
+
+	// Failed-login counters per username / client IP (see login_throttle.go)
+	loginThrottleSQL := `
+	CREATE TABLE IF NOT EXISTS login_throttle (
+		kind VARCHAR(8) NOT NULL,
+		key_value VARCHAR(255) NOT NULL,
+		failures INT NOT NULL DEFAULT 0,
+		last_failure_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		locked_until TIMESTAMP NULL DEFAULT NULL,
+		PRIMARY KEY (kind, key_value)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+	if _, err := db.Exec(loginThrottleSQL); err != nil {
+		return fmt.Errorf("create login_throttle: %w", err)
+	}
+
+	// Every login attempt, successful or not
+	loginHistorySQL := `
+	CREATE TABLE IF NOT EXISTS login_history (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		username VARCHAR(255) NOT NULL,
+		client_ip VARCHAR(64) NOT NULL,
+		user_agent VARCHAR(255) NOT NULL DEFAULT '',
+		result VARCHAR(32) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_login_history_username (username),
+		INDEX idx_login_history_created (created_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+	if _, err := db.Exec(loginHistorySQL); err != nil {
+		return fmt.Errorf("create login_history: %w", err)
+	}
+
+	// Named roles and the permissions they grant (see permissions.go)
+	rolesSQL := `
+	CREATE TABLE IF NOT EXISTS roles (
+		name VARCHAR(32) PRIMARY KEY,
+		description VARCHAR(255) NOT NULL DEFAULT ''
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+	if _, err := db.Exec(rolesSQL); err != nil {
+		return fmt.Errorf("create roles: %w", err)
+	}
+	rolePermissionsSQL := `
+	CREATE TABLE IF NOT EXISTS role_permissions (
+		role VARCHAR(32) NOT NULL,
+		permission VARCHAR(64) NOT NULL,
+		PRIMARY KEY (role, permission)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+	if _, err := db.Exec(rolePermissionsSQL); err != nil {
+		return fmt.Errorf("create role_permissions: %w", err)
+	}
+	if err := seedRoles(); err != nil {
+		return err
+	}
+
+	// Append-only record of mutating requests (see audit.go)
+	auditEventsSQL := `
+	CREATE TABLE IF NOT EXISTS audit_events (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		actor VARCHAR(255) NOT NULL DEFAULT '',
+		action VARCHAR(64) NOT NULL,
+		target VARCHAR(255) NOT NULL DEFAULT '',
+		client_ip VARCHAR(64) NOT NULL DEFAULT '',
+		result VARCHAR(16) NOT NULL,
+		status INT NOT NULL,
+		INDEX idx_audit_events_created (created_at),
+		INDEX idx_audit_events_actor (actor),
+		INDEX idx_audit_events_action (action)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+	if _, err := db.Exec(auditEventsSQL); err != nil {
+		return fmt.Errorf("create audit_events: %w", err)
+	}
+	// Triggers need the TRIGGER privilege; the table is still append-only from the service's side without them
+	for _, op := range []string{"UPDATE", "DELETE"} {
+		if _, err := db.Exec(fmt.Sprintf(`
+			CREATE TRIGGER IF NOT EXISTS audit_events_no_%s BEFORE %s ON audit_events
+			FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only'`,
+			strings.ToLower(op), op)); err != nil {
+			log.Printf("Warning: could not create audit_events %s trigger: %v", op, err)
+		}
+	}
+
 	// Seed default admin user if not exists
 	var count int
 	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", "nvidia").Scan(&count); err != nil {
@@ -67,11 +221,16 @@ func initDB() error {
 		if err != nil {
 			return fmt.Errorf("seed admin id: %w", err)
 		}
-		if _, err := db.Exec("INSERT INTO users (id, username, password, role) VALUES (?, ?, ?, ?)",
-			newID, "nvidia", "nvidia", "admin"); err != nil {
+		passwordHash, err := hashPassword("nvidia")
+		if err != nil {
+			return fmt.Errorf("seed admin password: %w", err)
+		}
+		// The default password is public knowledge, so it must be changed on first login
+		if _, err := db.Exec("INSERT INTO users (id, username, password, role, must_change_password) VALUES (?, ?, ?, ?, TRUE)",
+			newID, "nvidia", passwordHash, "admin"); err != nil {
 			return fmt.Errorf("seed admin insert: %w", err)
 		}
-		log.Printf("Seeded default admin user 'nvidia' with role 'admin'.")
+		log.Printf("Seeded default admin user 'nvidia' with role 'admin' (password change required on first login).")
 	}
 	return nil
 }
@@ -97,4 +256,66 @@ func getELFByBuildID(buildID string) (string, []byte, error) {
 	return name, blob, nil
 }
 
+// findELFsByFileName lists up to limit ELFs stored under elfFileName; the filename is not
+// unique, e.g. when one ELF was uploaded again after a rebuild
+func findELFsByFileName(elfFileName string, limit int) ([]ElfRecord, error) {
+	return queryElfRecords("SELECT build_id, elf_filename FROM build_elves WHERE elf_filename = ? ORDER BY build_id LIMIT ?", elfFileName, limit)
+}
+
+// findELFsByBuildIDPrefix lists up to limit ELFs whose Build ID starts with prefix (hex digits)
+func findELFsByBuildIDPrefix(prefix string, limit int) ([]ElfRecord, error) {
+	return queryElfRecords("SELECT build_id, elf_filename FROM build_elves WHERE build_id LIKE ? ORDER BY build_id LIMIT ?", prefix+"%", limit)
+}
+
+// findELFsByPushtag lists the ELFs stored for pushtag (see elfPushtag)
+func findELFsByPushtag(pushtag string) ([]ElfRecord, error) {
+	like := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(pushtag)
+	records, err := queryElfRecords("SELECT build_id, elf_filename FROM build_elves WHERE elf_filename LIKE ? ORDER BY elf_filename", `%\_\_`+like+`\_\_%`)
+	if err != nil {
+		return nil, err
+	}
+	// LIKE also matches the pushtag as part of a longer one
+	matching := records[:0]
+	for _, rec := range records {
+		if elfPushtag(rec.ElfFileName) == pushtag {
+			matching = append(matching, rec)
+		}
+	}
+	return matching, nil
+}
+
+func queryElfRecords(query string, args ...interface{}) ([]ElfRecord, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []ElfRecord
+	for rows.Next() {
+		var rec ElfRecord
+		if err := rows.Scan(&rec.BuildID, &rec.ElfFileName); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// getELFNameByBuildID returns only the stored filename (no blob)
+func getELFNameByBuildID(buildID string) (string, error) {
+	var name string
+	err := db.QueryRow("SELECT elf_filename FROM build_elves WHERE build_id = ?", buildID).Scan(&name)
+	return name, err
+}
+
+// elfPushtag extracts the pushtag from a stored filename of the form
+// display-t234-dce-log.elf__<pushtag>__<buildId> ("" for names without one).
+func elfPushtag(elfFileName string) string {
+	parts := strings.Split(elfFileName, "__")
+	if len(parts) < 3 {
+		return ""
+	}
+	return strings.Join(parts[1:len(parts)-1], "__")
+}
+
 
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- Async decode jobs ----------------
+// ------------------------------------------------------------------------------------------------
+
+// POST /api/decode/jobs stores the upload in a per-job work directory and decodes it in the
+// background. Progress is reported like a by-URL job (status snapshot and SSE); the output
+// stays downloadable until DECODE_JOB_RESULT_TTL after the job finished, then the reaper
+// deletes the work directory (a failed job's at once). Jobs live in memory and are visible to
+// their owner only; an owner may have DECODE_JOB_MAX_ACTIVE running at a time (0 = no limit).
+var (
+	decodeJobResultTTL   = parseDurationEnv("DECODE_JOB_RESULT_TTL", "30m")
+	decodeJobReaperEvery = parseDurationEnv("DECODE_JOB_REAPER_INTERVAL", "1m")
+	decodeJobMaxActive   = parseIntEnv("DECODE_JOB_MAX_ACTIVE", 4)
+)
+
+// DecodeJob is a background decode. Progress, status and subscribers are those of ByURLJob,
+// so snapshots and SSE events have the same shape as for by-URL jobs.
+type DecodeJob struct {
+	ByURLJob
+	Owner    string
+	FileName string
+
+	workDir    string
+	outputPath string // set once the job is done
+	errStatus  int    // HTTP status of the failure once the job errored
+	errCode    string // decodeError code of the failure
+	warnings   []string
+	candidates []ELFCandidate // matching ELFs when the selection was ambiguous
+	// queuePosition is the 1-based position in the decoder queue while waiting for a worker
+	queuePosition int
+}
+
+// DecodeJobManager holds decode jobs by ID.
+type DecodeJobManager struct {
+	mu   sync.Mutex
+	jobs map[string]*DecodeJob
+}
+
+var decodeJobs = &DecodeJobManager{jobs: make(map[string]*DecodeJob)}
+
+func (m *DecodeJobManager) Create(owner, fileName, workDir, pushtag string) *DecodeJob {
+	job := newDecodeJob(owner, fileName, workDir, pushtag)
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	m.mu.Unlock()
+	return job
+}
+
+// CreateWithin creates a job unless owner already has decodeJobMaxActive running ones.
+func (m *DecodeJobManager) CreateWithin(owner, fileName, workDir, pushtag string) (*DecodeJob, bool) {
+	job := newDecodeJob(owner, fileName, workDir, pushtag)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if decodeJobMaxActive > 0 && m.activeLocked(owner) >= decodeJobMaxActive {
+		return nil, false
+	}
+	m.jobs[job.ID] = job
+	return job, true
+}
+
+// active counts the running jobs of owner.
+func (m *DecodeJobManager) active(owner string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.activeLocked(owner)
+}
+
+func (m *DecodeJobManager) activeLocked(owner string) int {
+	n := 0
+	for _, j := range m.jobs {
+		if j.Owner != owner {
+			continue
+		}
+		j.mu.Lock()
+		if j.Status == JobRunning {
+			n++
+		}
+		j.mu.Unlock()
+	}
+	return n
+}
+
+func newDecodeJob(owner, fileName, workDir, pushtag string) *DecodeJob {
+	id, _ := randomIDHex(12)
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &DecodeJob{
+		ByURLJob: ByURLJob{
+			ID:          id,
+			Pushtag:     pushtag,
+			ctx:         ctx,
+			cancelFunc:  cancel,
+			Steps:       []string{"Queued..."},
+			Total:       4, // keep in sync with the runDecode stages plus "Completed."
+			Status:      JobRunning,
+			StartedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+			subscribers: make(map[chan ByURLEvent]struct{}),
+		},
+		Owner:    owner,
+		FileName: fileName,
+		workDir:  workDir,
+	}
+	return job
+}
+
+// keepResult registers a finished synchronous decode as a done job, so that its output can be
+// fetched from the job's result URL until decodeJobResultTTL. The job owns workDir from then on.
+func (m *DecodeJobManager) keepResult(owner, fileName, workDir, pushtag string, res *decodeResult) *DecodeJob {
+	job := m.Create(owner, fileName, workDir, pushtag)
+	job.cancelFunc()
+	job.mu.Lock()
+	job.outputPath = res.OutputPath
+	job.warnings = res.Warnings
+	job.mu.Unlock()
+	job.broadcast(ByURLEvent{Type: "done", Message: "Completed.", StepIndex: job.Total - 1, TotalSteps: job.Total, BuildID: res.BuildID, ElfName: res.ElfFileName})
+	return job
+}
+
+// Get returns the job only to its owner, so job IDs of other users cannot be probed.
+func (m *DecodeJobManager) Get(jobID, owner string) (*DecodeJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[jobID]
+	if !ok || j.Owner != owner {
+		return nil, false
+	}
+	return j, true
+}
+
+// Remove forgets a job and deletes its work directory.
+func (m *DecodeJobManager) Remove(jobID string) {
+	m.mu.Lock()
+	j, ok := m.jobs[jobID]
+	delete(m.jobs, jobID)
+	m.mu.Unlock()
+	if ok {
+		os.RemoveAll(j.workDir)
+	}
+}
+
+// state reads the fields the handlers need under the job lock.
+func (j *DecodeJob) state() (status ByURLJobStatus, errMsg string, errStatus int, outputPath string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.Status, j.ErrMsg, j.errStatus, j.outputPath
+}
+
+func (j *DecodeJob) errorCode() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.errCode
+}
+
+func (j *DecodeJob) elfCandidates() []ELFCandidate {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.candidates
+}
+
+func (j *DecodeJob) decodeWarnings() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.warnings
+}
+
+func (j *DecodeJob) queued() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.queuePosition
+}
+
+// performDecodeJob runs runDecode for job and reports each stage to its subscribers. req holds
+// the request fields (Select, Options, ELF); the job supplies the rest.
+func performDecodeJob(job *DecodeJob, req decodeRequest) {
+	defer job.cancelFunc()
+	step := 0
+	emitStep := func(msg string) {
+		job.mu.Lock()
+		job.queuePosition = 0
+		job.mu.Unlock()
+		job.broadcast(ByURLEvent{Type: "step", Message: msg, StepIndex: step, TotalSteps: job.Total})
+		step++
+	}
+	req.WorkDir = job.workDir
+	req.LogPath = filepath.Join(job.workDir, "dce-enc.log")
+	req.Owner = job.Owner
+	req.Progress = emitStep
+	req.OnQueued = func(position int) {
+		job.mu.Lock()
+		job.queuePosition = position
+		job.mu.Unlock()
+		job.broadcast(ByURLEvent{Type: "queue", Message: strconv.Itoa(position), TotalSteps: job.Total})
+	}
+	res, err := runDecode(job.ctx, req)
+	job.mu.Lock()
+	job.warnings = res.Warnings
+	job.mu.Unlock()
+	if err != nil {
+		status, code := decodeErrorStatus(err), decodeErrorCode(err)
+		if job.ctx.Err() != nil {
+			err, status, code = fmt.Errorf("cancelled by user"), http.StatusConflict, codeDecodeCancelled
+		}
+		job.mu.Lock()
+		job.BuildID = res.BuildID
+		job.errStatus = status
+		job.errCode = code
+		job.candidates = elfCandidates(err)
+		job.queuePosition = 0
+		job.mu.Unlock()
+		// Nothing can be fetched from a failed job; only its status stays until the TTL
+		os.RemoveAll(job.workDir)
+		job.broadcast(ByURLEvent{Type: "error", Message: err.Error(), StepIndex: step, TotalSteps: job.Total, BuildID: res.BuildID})
+		return
+	}
+	job.mu.Lock()
+	job.outputPath = res.OutputPath
+	job.mu.Unlock()
+	emitStep("Completed.")
+	job.broadcast(ByURLEvent{Type: "done", Message: "Completed.", StepIndex: step, TotalSteps: job.Total, BuildID: res.BuildID, ElfName: res.ElfFileName})
+}
+
+func decodeJobURL(jobID, suffix string) string {
+	return "/api/decode/jobs/" + jobID + suffix
+}
+
+// decodeJobsHandler starts a decode job.
+// POST multipart with field `file` (optional `buildId`, `pushtag` and decode options as for
+// /api/decode) -> 202 {jobId, ...}
+func decodeJobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "Only POST is supported.", http.StatusMethodNotAllowed)
+		return
+	}
+	if decoders.full() {
+		setRetryAfter(w)
+		writeJSONError(w, "All decoders are busy and the queue is full; please retry later.", http.StatusTooManyRequests)
+		return
+	}
+	owner := principalFromRequest(r).Username
+	tooManyJobs := func() {
+		setRetryAfter(w)
+		writeJSONError(w, fmt.Sprintf("You already have %d decode jobs running; wait for one to finish or cancel it.", decodeJobMaxActive), http.StatusTooManyRequests)
+	}
+	// Checked again when the job is created; this spares reading the upload
+	if decodeJobMaxActive > 0 && decodeJobs.active(owner) >= decodeJobMaxActive {
+		tooManyJobs()
+		return
+	}
+	if err := r.ParseMultipartForm(100 << 20); err != nil {
+		writeJSONError(w, "Invalid multipart form data.", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSONError(w, "Missing uploaded file field 'file'.", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	setAuditTarget(r, "file="+header.Filename)
+	opts, err := parseDecodeOptions(r.FormValue)
+	if err != nil {
+		writeJSONError(w, "Invalid decode options: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	sel, err := parseELFSelection(r.FormValue)
+	if err != nil {
+		writeJSONError(w, "Invalid ELF selection: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	workDir, err := os.MkdirTemp("", "dce-decode-job-")
+	if err != nil {
+		log.Printf("Error creating temp dir: %v", err)
+		writeJSONError(w, "Internal server error: Cannot create temp directory.", http.StatusInternalServerError)
+		return
+	}
+	if err := saveUpload(file, filepath.Join(workDir, "dce-enc.log")); err != nil {
+		os.RemoveAll(workDir)
+		writeJSONError(w, "Internal server error: Cannot write uploaded file.", http.StatusInternalServerError)
+		return
+	}
+	adhoc, err := saveAdhocELF(r, workDir)
+	if err != nil {
+		os.RemoveAll(workDir)
+		writeJSONError(w, err.Error(), decodeErrorStatus(err))
+		return
+	}
+	job, ok := decodeJobs.CreateWithin(owner, header.Filename, workDir, sel.Pushtag)
+	if !ok {
+		os.RemoveAll(workDir)
+		tooManyJobs()
+		return
+	}
+	go performDecodeJob(job, decodeRequest{Select: sel, Options: opts, ELF: adhoc})
+	log.Printf("Decode job %s started for %s (%s)", job.ID, job.Owner, header.Filename)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"jobId":     job.ID,
+		"statusUrl": decodeJobURL(job.ID, ""),
+		"streamUrl": decodeJobURL(job.ID, "/stream"),
+		"resultUrl": decodeJobURL(job.ID, "/result"),
+	})
+}
+
+// decodeJobHandler: GET -> snapshot of the job; DELETE -> cancel a running job, or drop a
+// finished one together with its result.
+func decodeJobHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	setAuditTarget(r, "id="+r.PathValue("id"))
+	job, ok := decodeJobs.Get(r.PathValue("id"), principalFromRequest(r).Username)
+	if !ok {
+		writeJSONError(w, "job not found.", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		snap := job.snapshot()
+		var payload map[string]interface{}
+		_ = json.Unmarshal([]byte(snap.Message), &payload)
+		status, errMsg, _, _ := job.state()
+		payload["success"] = true
+		payload["jobId"] = job.ID
+		payload["fileName"] = job.FileName
+		if status == JobError {
+			payload["error"] = errMsg
+			payload["errorCode"] = job.errorCode()
+			if candidates := job.elfCandidates(); len(candidates) > 0 {
+				payload["candidates"] = candidates
+			}
+		}
+		if status == JobDone {
+			payload["resultUrl"] = decodeJobURL(job.ID, "/result")
+		}
+		if position := job.queued(); position > 0 {
+			payload["queuePosition"] = position
+		}
+		if warnings := job.decodeWarnings(); len(warnings) > 0 {
+			payload["warnings"] = warnings
+		}
+		json.NewEncoder(w).Encode(payload)
+	case http.MethodDelete:
+		if status, _, _, _ := job.state(); status == JobRunning {
+			// the job goroutine emits the error once the decoder has stopped
+			job.cancelFunc()
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "cancel requested"})
+			return
+		}
+		decodeJobs.Remove(job.ID)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "job cleared"})
+	default:
+		writeJSONError(w, "Only GET and DELETE are supported.", http.StatusMethodNotAllowed)
+	}
+}
+
+// decodeJobStreamHandler streams job progress as SSE (queue|step|error|done) and ends after the
+// terminal event.
+func decodeJobStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Only GET is supported.", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, "Streaming unsupported.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	job, ok := decodeJobs.Get(r.PathValue("id"), principalFromRequest(r).Username)
+	if !ok {
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", "job not found")
+		flusher.Flush()
+		return
+	}
+	ch := job.addSubscriber()
+	defer job.removeSubscriber(ch)
+
+	b, _ := json.Marshal(map[string]string{"jobId": job.ID})
+	fmt.Fprintf(w, "event: meta\ndata: %s\n\n", string(b))
+	if position := job.queued(); position > 0 {
+		fmt.Fprintf(w, "event: queue\ndata: %d\n\n", position)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case ev := <-ch:
+			switch ev.Type {
+			case "step":
+				fmt.Fprintf(w, "event: step\ndata: %s\n\n", ev.Message)
+			case "queue":
+				fmt.Fprintf(w, "event: queue\ndata: %s\n\n", ev.Message)
+			case "error":
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", ev.Message)
+			case "done":
+				b, _ := json.Marshal(map[string]string{"buildId": ev.BuildID, "elfFileName": ev.ElfName, "resultUrl": decodeJobURL(job.ID, "/result")})
+				fmt.Fprintf(w, "event: done\ndata: %s\n\n", string(b))
+			}
+			flusher.Flush()
+			if ev.Type == "error" || ev.Type == "done" {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// decodeJobResultHandler returns dce-decoded.log of a finished job (as records for
+// ?output=ndjson|csv): 409 while it is running, and the job's failure status with its message
+// if it errored.
+func decodeJobResultHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Only GET is supported.", http.StatusMethodNotAllowed)
+		return
+	}
+	job, ok := decodeJobs.Get(r.PathValue("id"), principalFromRequest(r).Username)
+	if !ok {
+		writeJSONError(w, "job not found.", http.StatusNotFound)
+		return
+	}
+	output, err := parseOutputFormat(r.URL.Query().Get("output"), r.Header.Get("Accept"))
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	status, errMsg, errStatus, outputPath := job.state()
+	switch status {
+	case JobRunning:
+		writeJSONError(w, "Job is still running.", http.StatusConflict)
+		return
+	case JobError:
+		if errStatus == http.StatusTooManyRequests {
+			setRetryAfter(w)
+		}
+		w.Header().Set("X-Decode-Error", job.errorCode())
+		writeJSONError(w, errMsg, errStatus)
+		return
+	}
+	snap := job.snapshot()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"dce-decoded.log\"")
+	w.Header().Set("X-Build-Id", snap.BuildID)
+	w.Header().Set("X-ELF-File", snap.ElfName)
+	if warnings := job.decodeWarnings(); len(warnings) > 0 {
+		w.Header().Set("X-Decode-Warning", strings.Join(warnings, "; "))
+	}
+	// The job status is the JSON view of a job; output=json serves the text as is
+	if output == outputNDJSON || output == outputCSV {
+		if err := writeDecodedRecords(w, outputPath, output); err != nil {
+			writeJSONError(w, "Internal server error: Cannot read decoded log.", http.StatusInternalServerError)
+		}
+		return
+	}
+	http.ServeFile(w, r, outputPath)
+}
+
+// startDecodeJobReaper drops finished jobs and their results after decodeJobResultTTL.
+// Running jobs are never reaped; the decoder is bounded by the job context.
+func startDecodeJobReaper() {
+	ticker := time.NewTicker(decodeJobReaperEvery)
+	go func() {
+		for range ticker.C {
+			now := time.Now()
+			var expired []string
+			decodeJobs.mu.Lock()
+			for id, job := range decodeJobs.jobs {
+				job.mu.Lock()
+				finished := job.Status == JobDone || job.Status == JobError
+				if finished && now.Sub(job.UpdatedAt) > decodeJobResultTTL {
+					expired = append(expired, id)
+				}
+				job.mu.Unlock()
+			}
+			decodeJobs.mu.Unlock()
+			for _, id := range expired {
+				decodeJobs.Remove(id)
+			}
+		}
+	}()
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"decode-dce-log-service/backend/nvlog"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- Decode options ----------------
+// ------------------------------------------------------------------------------------------------
+
+// The decode endpoints accept a fixed set of form fields that map to nvlog_decoder flags; the
+// values are validated here and the flags are built by decodeOptions.args, so nothing from the
+// request reaches the command line unchecked.
+//
+//	format        DCE (default) | GSP_LIBOS2 | GSP_LIBOS3 | PMU  -> -f <format> (bare LIBOS buffer)
+//	              NVLOG                                          -> full nvlog dump, no -f
+//	tag           buffer / LibOS task tag                        -> -t <tag>
+//	bufferIndex   buffer index inside an nvlog dump              -> -n <index>
+//	singleBuffer  input holds a single nvlog buffer              -> -b
+//	reverse       decode the buffer in reverse order             -> -r
+//	textDecode    input is a first-pass decoded text dump        -> -T none instead of -d none
+const (
+	formatDCE       = "DCE"
+	formatGSPLibos2 = "GSP_LIBOS2"
+	formatGSPLibos3 = "GSP_LIBOS3"
+	formatPMU       = "PMU"
+	formatNvlogDump = "NVLOG"
+)
+
+var decodeFormats = []string{formatDCE, formatGSPLibos2, formatGSPLibos3, formatPMU, formatNvlogDump}
+
+// maxBufferIndex is the last entry of the largest nvlog dump buffer table (version 0xd).
+const maxBufferIndex = 0xeff
+
+var decodeTagPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,31}$`)
+
+// codeInvalidOptions reports a rejected decode option.
+const codeInvalidOptions = "invalid_options"
+
+// decodeOptions selects how nvlog_decoder interprets the input. Build it with
+// parseDecodeOptions or defaultDecodeOptions; BufferIndex is -1 when no index was given.
+type decodeOptions struct {
+	Format       string
+	Tag          string
+	BufferIndex  int
+	SingleBuffer bool
+	Reverse      bool
+	TextDecode   bool
+}
+
+func defaultDecodeOptions() decodeOptions {
+	return decodeOptions{Format: formatDCE, BufferIndex: -1}
+}
+
+// parseDecodeOptions reads the option fields through get (e.g. r.FormValue) and validates them.
+func parseDecodeOptions(get func(string) string) (decodeOptions, error) {
+	o := defaultDecodeOptions()
+	if f := strings.TrimSpace(get("format")); f != "" {
+		o.Format = ""
+		for _, known := range decodeFormats {
+			if strings.EqualFold(f, known) {
+				o.Format = known
+			}
+		}
+		if o.Format == "" {
+			return o, fmt.Errorf("unknown format %q (expected one of %s)", f, strings.Join(decodeFormats, ", "))
+		}
+	}
+	if t := strings.TrimSpace(get("tag")); t != "" {
+		if !decodeTagPattern.MatchString(t) {
+			return o, fmt.Errorf("invalid tag %q (letters, digits, '_', '.', '-'; at most 32 characters)", t)
+		}
+		o.Tag = t
+	}
+	if s := strings.TrimSpace(get("bufferIndex")); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > maxBufferIndex {
+			return o, fmt.Errorf("invalid bufferIndex %q (expected 0..%d)", s, maxBufferIndex)
+		}
+		o.BufferIndex = n
+	}
+	for _, b := range []struct {
+		field string
+		dst   *bool
+	}{{"singleBuffer", &o.SingleBuffer}, {"reverse", &o.Reverse}, {"textDecode", &o.TextDecode}} {
+		v, err := parseFormBool(get(b.field))
+		if err != nil {
+			return o, fmt.Errorf("invalid %s: %v", b.field, err)
+		}
+		*b.dst = v
+	}
+	// nvlog_decoder rejects these combinations itself; fail early with a clear message
+	if o.TextDecode && (o.Format != formatDCE || o.Tag != "" || o.BufferIndex >= 0 || o.SingleBuffer || o.Reverse) {
+		return o, fmt.Errorf("textDecode cannot be combined with format, tag, bufferIndex, singleBuffer or reverse")
+	}
+	if o.BufferIndex >= 0 && o.Format != formatNvlogDump {
+		return o, fmt.Errorf("bufferIndex requires format %s", formatNvlogDump)
+	}
+	return o, nil
+}
+
+// parseFormBool accepts the usual boolean spellings, including HTML checkbox "on".
+func parseFormBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "0", "false", "off", "no":
+		return false, nil
+	case "1", "true", "on", "yes":
+		return true, nil
+	}
+	return false, fmt.Errorf("%q is not a boolean", s)
+}
+
+// parsesHeader reports whether the input is a binary log whose header the service can read.
+// Text dumps and single-buffer files are passed to the decoder as they are.
+func (o decodeOptions) parsesHeader() bool {
+	return !o.TextDecode && !o.SingleBuffer
+}
+
+// checkHeader verifies that the parsed header fits the options and returns the Build ID of the
+// selected buffer ("" if it has none).
+func (o decodeOptions) checkHeader(h *nvlog.Header) (string, error) {
+	if o.Format != formatNvlogDump {
+		if h.Container != nvlog.ContainerLibos {
+			return "", fmt.Errorf("uploaded file is a full nvlog dump; upload the extracted %s log buffer or use format %s", o.Format, formatNvlogDump)
+		}
+		return h.BuildID, nil
+	}
+	if h.Container != nvlog.ContainerDump {
+		return "", fmt.Errorf("format %s expects a full nvlog dump, but the upload is a bare LIBOS buffer", formatNvlogDump)
+	}
+	if o.BufferIndex < 0 && o.Tag == "" {
+		return h.BuildID, nil
+	}
+	var available []string
+	for _, b := range h.Buffers {
+		available = append(available, fmt.Sprintf("%d:%s", b.Index, b.Tag))
+		if (o.BufferIndex >= 0 && b.Index != o.BufferIndex) || (o.Tag != "" && !strings.EqualFold(b.Tag, o.Tag)) {
+			continue
+		}
+		if b.Libos != nil {
+			return b.Libos.BuildID, nil
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("no buffer matches the selection (tag %q, bufferIndex %d); buffers: %s", o.Tag, o.BufferIndex, strings.Join(available, ", "))
+}
+
+// args returns the nvlog_decoder flags for the options, without input, output and ELF.
+// elfDir is the directory holding the ELF, used as PMU base path.
+func (o decodeOptions) args(elfDir string) []string {
+	if o.TextDecode {
+		return []string{"-T", "none"}
+	}
+	args := []string{"-d", "none"}
+	if o.Format != formatNvlogDump {
+		args = append(args, "-f", o.Format)
+	}
+	if o.Format == formatPMU {
+		args = append(args, "-P", elfDir)
+	}
+	if o.Tag != "" {
+		args = append(args, "-t", o.Tag)
+	}
+	if o.BufferIndex >= 0 {
+		args = append(args, "-n", strconv.Itoa(o.BufferIndex))
+	}
+	if o.SingleBuffer {
+		args = append(args, "-b")
+	}
+	if o.Reverse {
+		args = append(args, "-r")
+	}
+	return args
+}
+
+// String summarises the options for logs.
+func (o decodeOptions) String() string {
+	s := "format=" + o.Format
+	if o.Tag != "" {
+		s += " tag=" + o.Tag
+	}
+	if o.BufferIndex >= 0 {
+		s += " bufferIndex=" + strconv.Itoa(o.BufferIndex)
+	}
+	for _, f := range []struct {
+		name string
+		on   bool
+	}{{"singleBuffer", o.SingleBuffer}, {"reverse", o.Reverse}, {"textDecode", o.TextDecode}} {
+		if f.on {
+			s += " " + f.name
+		}
+	}
+	return s
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- JSON decode response ----------------
+// ------------------------------------------------------------------------------------------------
+
+// POST /api/decode returns dce-decoded.log as an attachment unless the client sends
+// `Accept: application/json`; it then gets a DecodeResponse, also for failures. Outputs up to
+// DECODE_INLINE_MAX_KB are inlined as decodedLog; larger ones are kept as a finished decode job
+// and linked by resultUrl until DECODE_JOB_RESULT_TTL.
+
+var decodeInlineMaxBytes = int64(parseIntEnv("DECODE_INLINE_MAX_KB", 1024)) << 10
+
+// decoderStderrExcerpt bounds DecoderStatus.Stderr.
+const decoderStderrExcerpt = 4 << 10
+
+// wantsJSON reports whether the client asked for a DecodeResponse.
+func wantsJSON(accept string) bool {
+	return strings.Contains(accept, "application/json")
+}
+
+// newDecodeResponse describes a decode and its error (nil on success); the decoded log itself
+// is added by attachDecodedLog.
+func newDecodeResponse(res *decodeResult, err error, sel elfSelection) DecodeResponse {
+	resp := DecodeResponse{
+		ResponsePayload: ResponsePayload{ElfFileName: res.ElfFileName, Message: "Decoded.", Success: err == nil},
+		BuildID:         res.BuildID,
+		Pushtag:         elfPushtag(res.ElfFileName),
+		Warnings:        res.Warnings,
+		QueuePosition:   res.QueuePosition,
+		Timings:         DecodeTimings{QueueWaitMs: res.QueueWait.Milliseconds()},
+	}
+	if resp.Pushtag == "" && sel.pinsPushtag() {
+		resp.Pushtag = sel.Pushtag
+	}
+	if err != nil {
+		resp.Message = err.Error()
+		resp.ErrorCode = decodeErrorCode(err)
+		resp.Candidates = elfCandidates(err)
+	}
+	if res.Decoder != nil {
+		resp.Decoder = decoderStatus(res.Decoder)
+		resp.Timings.DecoderMs = res.Decoder.Duration.Milliseconds()
+	}
+	return resp
+}
+
+// decoderStatus summarises run; the excerpt keeps the end of the output, where the decoder
+// reports why it stopped.
+func decoderStatus(run *decoderRun) *DecoderStatus {
+	out := bytes.TrimRight(run.Output, "\r\n")
+	st := &DecoderStatus{ExitCode: run.ExitCode}
+	if len(out) > decoderStderrExcerpt {
+		out = out[len(out)-decoderStderrExcerpt:]
+		if i := bytes.IndexByte(out, '\n'); i >= 0 {
+			out = out[i+1:]
+		}
+		st.StderrTruncated = true
+	}
+	st.Stderr = strings.ToValidUTF8(string(out), "�")
+	return st
+}
+
+// attachDecodedLog adds line count and size of the decoded log at path to resp and inlines it
+// when it fits decodeInlineMaxBytes. Otherwise keep is called to preserve the file and returns
+// the URL it can be fetched from.
+func attachDecodedLog(resp *DecodeResponse, path string, keep func() string) error {
+	lines, size, err := countLines(path)
+	if err != nil {
+		return err
+	}
+	resp.LineCount, resp.OutputBytes = lines, size
+	if size > decodeInlineMaxBytes {
+		resp.ResultURL = keep()
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	resp.DecodedLog = strings.ToValidUTF8(string(b), "�")
+	return nil
+}
+
+// countLines returns the number of lines in the file at path (a last line without a newline
+// counts) and its size.
+func countLines(path string) (int, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	buf := make([]byte, 64<<10)
+	lines, size, last := 0, int64(0), byte('\n')
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			lines += bytes.Count(buf[:n], []byte{'\n'})
+			size += int64(n)
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if last != '\n' {
+		lines++
+	}
+	return lines, size, nil
+}
+
+// writeDecodeResponse writes resp with the given HTTP status.
+func writeDecodeResponse(w http.ResponseWriter, status int, resp DecodeResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// sinceMs is the time since start in milliseconds, for DecodeTimings.TotalMs.
+func sinceMs(start time.Time) int64 {
+	return time.Since(start).Milliseconds()
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- Decoded log records ----------------
+// ------------------------------------------------------------------------------------------------
+
+// nvlog_decoder writes one text line per log entry. parseDecodedLine turns a line into a
+// LogRecord; the decode API streams the records as NDJSON or CSV (`output` field). Two prefixes
+// are recognised, each optionally followed by the wall-clock time "2006-01-02 15:04:05":
+//
+//	LIBOS (DCE, GSP, PMU)  T:<ticks> GPU<n> <source>-<task>: <file>(<line>): <message>
+//	nvlog (RM)             [T:<sec>.<usec> GPU: <level> <module> <tag> <file>.<ext>:<line>] <message>
+//
+// Other lines (buffer banners, "**** Buffer wrapped ... ****" markers, wrapped message text)
+// become records holding only the message, so every line of the text output has one record.
+
+// Output formats of the decode API (field `output`, or the matching Accept header).
+const (
+	outputText   = "text"
+	outputJSON   = "json"
+	outputNDJSON = "ndjson"
+	outputCSV    = "csv"
+)
+
+var (
+	decodedTimePattern  = `(?:(\d{4}-\d{2}-\d{2}) (\d{1,2}:\d{2}:\d{2}) )?`
+	libosLinePattern    = regexp.MustCompile(`^(?:T:(\d+) )?` + decodedTimePattern + `GPU(\d+) ?([^\s:]+?)-([^:]*): (.*?)\((\d+)\): ?(.*)$`)
+	nvlogLinePattern    = regexp.MustCompile(`^\[T:([0-9.]+) ` + decodedTimePattern + `GPU: ?(\S+)\s+(\S+)\s+(\S+)\s+([^\]]*?)\s*\] ?(.*)$`)
+	nvlogSourcePattern  = regexp.MustCompile(`^(\S+?)\s*\.(\w+):(\d+)$`)
+	errorMessagePattern = regexp.MustCompile(`^(?i:(?:error|err|fatal|assertion failed|check failed)\b)`)
+	warnMessagePattern  = regexp.MustCompile(`^(?i:(?:warning|warn)\b)`)
+)
+
+// decodedLogCSVHeader names the CSV columns, in the order of decodedLogCSVRow.
+var decodedLogCSVHeader = []string{"line", "timestamp", "ticks", "severity", "module", "tag", "file", "sourceLine", "message"}
+
+// parseOutputFormat validates the `output` field; when it is empty the Accept header decides.
+func parseOutputFormat(field, accept string) (string, error) {
+	switch f := strings.ToLower(strings.TrimSpace(field)); f {
+	case outputText, outputJSON, outputNDJSON, outputCSV:
+		return f, nil
+	case "":
+	default:
+		return "", fmt.Errorf("invalid output %q (expected text, json, ndjson or csv)", field)
+	}
+	switch {
+	case strings.Contains(accept, "application/x-ndjson"):
+		return outputNDJSON, nil
+	case strings.Contains(accept, "text/csv"):
+		return outputCSV, nil
+	case wantsJSON(accept):
+		return outputJSON, nil
+	}
+	return outputText, nil
+}
+
+// parseDecodedLine parses line n (1-based) of a decoded log.
+func parseDecodedLine(n int, line string) LogRecord {
+	line = strings.TrimRight(line, "\r\n")
+	rec := LogRecord{Line: n, Message: line}
+	if m := libosLinePattern.FindStringSubmatch(line); m != nil {
+		rec.Ticks, rec.Timestamp = m[1], decodedTimestamp(m[2], m[3])
+		rec.Module, rec.Tag = m[5], strings.TrimSpace(m[6])
+		rec.File, rec.SourceLine = m[7], atoiOrZero(m[8])
+		rec.Message = m[9]
+		rec.Severity = messageSeverity(rec.Message)
+		return rec
+	}
+	if m := nvlogLinePattern.FindStringSubmatch(line); m != nil {
+		rec.Ticks, rec.Timestamp = m[1], decodedTimestamp(m[2], m[3])
+		rec.Severity = nvlogSeverity(m[4])
+		rec.Module, rec.Tag = m[5], m[6]
+		if s := nvlogSourcePattern.FindStringSubmatch(m[7]); s != nil {
+			rec.File, rec.SourceLine = s[1]+"."+s[2], atoiOrZero(s[3])
+		}
+		rec.Message = m[8]
+		if rec.Severity == "" {
+			rec.Severity = messageSeverity(rec.Message)
+		}
+		return rec
+	}
+	if strings.HasPrefix(line, "****") {
+		// decoder markers for lost entries
+		rec.Severity = "warning"
+	}
+	return rec
+}
+
+// decodedTimestamp joins the date and time printed by the decoder ("" if there was none).
+func decodedTimestamp(date, clock string) string {
+	if date == "" {
+		return ""
+	}
+	if len(clock) == 7 { // the decoder does not pad the hour
+		clock = "0" + clock
+	}
+	return date + "T" + clock
+}
+
+// messageSeverity guesses the severity of a LIBOS entry, which carries no level, from the
+// start of its message.
+func messageSeverity(msg string) string {
+	msg = strings.TrimSpace(msg)
+	switch {
+	case errorMessagePattern.MatchString(msg):
+		return "error"
+	case warnMessagePattern.MatchString(msg):
+		return "warning"
+	}
+	return "info"
+}
+
+// nvlogSeverity maps an nvlog level column to error, warning, info or debug; unknown levels
+// are returned in lower case.
+func nvlogSeverity(level string) string {
+	switch l := strings.ToLower(level); l {
+	case "err", "error", "fatal":
+		return "error"
+	case "wrn", "warn", "warning":
+		return "warning"
+	case "inf", "info", "not", "notice":
+		return "info"
+	case "dbg", "debug", "reg", "spm", "spam":
+		return "debug"
+	default:
+		return l
+	}
+}
+
+func atoiOrZero(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func decodedLogCSVRow(rec LogRecord) []string {
+	sourceLine := ""
+	if rec.SourceLine > 0 {
+		sourceLine = strconv.Itoa(rec.SourceLine)
+	}
+	return []string{strconv.Itoa(rec.Line), rec.Timestamp, rec.Ticks, rec.Severity, rec.Module, rec.Tag, rec.File, sourceLine, rec.Message}
+}
+
+// writeDecodedRecords streams the decoded log at path as NDJSON or CSV records. The file is
+// opened before any header is written, so an error can still be reported normally.
+func writeDecodedRecords(w http.ResponseWriter, path, format string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var write func(LogRecord) error
+	var flush func()
+	switch format {
+	case outputCSV:
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="dce-decoded.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write(decodedLogCSVHeader)
+		write = func(rec LogRecord) error { return cw.Write(decodedLogCSVRow(rec)) }
+		flush = cw.Flush
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="dce-decoded.ndjson"`)
+		enc := json.NewEncoder(w)
+		write = func(rec LogRecord) error { return enc.Encode(rec) }
+		flush = func() {}
+	}
+	defer flush()
+
+	br := bufio.NewReaderSize(f, 64<<10)
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			if werr := write(parseDecodedLine(n, strings.ToValidUTF8(line, "�"))); werr != nil {
+				// the client went away
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			log.Printf("Reading decoded log %s failed after %d lines: %v", path, n, err)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestParseDecodedLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want LogRecord
+	}{
+		{
+			name: "libos",
+			line: "T:1234567890 GPU0 DCE-RM: dce_main.c(123): Hello world 0x1",
+			want: LogRecord{Ticks: "1234567890", Severity: "info", Module: "DCE", Tag: "RM", File: "dce_main.c", SourceLine: 123, Message: "Hello world 0x1"},
+		},
+		{
+			name: "libos with wall-clock time",
+			line: "T:1234567890 2024-01-02 3:04:05 GPU0 DCE-DCE_TASK: src/disp.c(7): ERROR: head 0 underflow",
+			want: LogRecord{Ticks: "1234567890", Timestamp: "2024-01-02T03:04:05", Severity: "error", Module: "DCE", Tag: "DCE_TASK", File: "src/disp.c", SourceLine: 7, Message: "ERROR: head 0 underflow"},
+		},
+		{
+			name: "libos without ticks",
+			line: "GPU0 DCE-RM: dce_main.c(9): Assertion failed: x (0x00000001) returned from foo",
+			want: LogRecord{Severity: "error", Module: "DCE", Tag: "RM", File: "dce_main.c", SourceLine: 9, Message: "Assertion failed: x (0x00000001) returned from foo"},
+		},
+		{
+			name: "libos warning",
+			line: "T:42 GPU1 GSP-INIT: init.c(88): Warning: clock not locked\r\n",
+			want: LogRecord{Ticks: "42", Severity: "warning", Module: "GSP", Tag: "INIT", File: "init.c", SourceLine: 88, Message: "Warning: clock not locked"},
+		},
+		{
+			name: "nvlog",
+			line: "[T:0000012345.678901 GPU: ERR   RESMAN     DISP nv_disp         .c:42   ] mode set failed",
+			want: LogRecord{Ticks: "0000012345.678901", Severity: "error", Module: "RESMAN", Tag: "DISP", File: "nv_disp.c", SourceLine: 42, Message: "mode set failed"},
+		},
+		{
+			name: "nvlog with wall-clock time",
+			line: "[T:0000012345.678901 2024-01-02 13:04:05 GPU: INF   RESMAN     GPU  gpu             .c:7    ] ok",
+			want: LogRecord{Ticks: "0000012345.678901", Timestamp: "2024-01-02T13:04:05", Severity: "info", Module: "RESMAN", Tag: "GPU", File: "gpu.c", SourceLine: 7, Message: "ok"},
+		},
+		{
+			name: "buffer banner",
+			line: "Decode DCE buffer:",
+			want: LogRecord{Message: "Decode DCE buffer:"},
+		},
+		{
+			name: "wrapped buffer marker",
+			line: "**** Buffer wrapped. Lost 12 entries from DCE-RM ****",
+			want: LogRecord{Severity: "warning", Message: "**** Buffer wrapped. Lost 12 entries from DCE-RM ****"},
+		},
+		{
+			name: "wrapped message text",
+			line: "  continuation text",
+			want: LogRecord{Message: "  continuation text"},
+		},
+		{
+			name: "empty line",
+			line: "\n",
+			want: LogRecord{},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.want.Line = i + 1
+			if got := parseDecodedLine(i+1, tt.line); got != tt.want {
+				t.Errorf("parseDecodedLine(%q)\n got %+v\nwant %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOutputFormat(t *testing.T) {
+	tests := []struct {
+		field, accept string
+		want          string
+		wantErr       bool
+	}{
+		{"", "", outputText, false},
+		{"", "application/json", outputJSON, false},
+		{"", "application/x-ndjson, application/json", outputNDJSON, false},
+		{"", "text/csv", outputCSV, false},
+		{" CSV ", "application/json", outputCSV, false},
+		{"text", "application/json", outputText, false},
+		{"xml", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseOutputFormat(tt.field, tt.accept)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseOutputFormat(%q, %q) = %q, %v; want %q (error %t)", tt.field, tt.accept, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
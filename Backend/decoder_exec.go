@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- nvlog_decoder execution limits ----------------
+// ------------------------------------------------------------------------------------------------
+
+// nvlog_decoder runs under the request (or job) context plus a wall-clock timeout, so it is
+// killed when the client goes away. Address space, CPU time and output file size are capped
+// with rlimits applied by prlimit(1) right before exec. A limit of 0 disables it.
+var (
+	decoderTimeout       = parseDurationEnv("DECODER_TIMEOUT", "5m")
+	decoderMaxAddressMB  = parseIntEnv("DECODER_MAX_ADDRESS_SPACE_MB", 4096)
+	decoderMaxCPUSeconds = parseIntEnv("DECODER_MAX_CPU_SECONDS", 300)
+	decoderMaxOutputMB   = parseIntEnv("DECODER_MAX_OUTPUT_MB", 512)
+)
+
+// Error codes of decoder failures (decodeError.Code).
+const (
+	codeDecoderTimeout     = "decoder_timeout"
+	codeDecoderCPULimit    = "decoder_cpu_limit"
+	codeDecoderMemoryLimit = "decoder_memory_limit"
+	codeDecoderOutputLimit = "decoder_output_limit"
+	codeDecoderFailed      = "decoder_failed"
+	codeDecoderNoOutput    = "decoder_no_output"
+	codeDecoderSandbox     = "decoder_sandbox_error"
+	codeDecodeCancelled    = "decode_cancelled"
+)
+
+// decoderOutputCap bounds the stdout/stderr kept for logging.
+const decoderOutputCap = 64 << 10
+
+// prlimitPath is empty when prlimit is unavailable; rlimits are then skipped with a warning.
+var prlimitPath = func() string {
+	p, err := exec.LookPath("prlimit")
+	if err != nil {
+		log.Printf("WARNING: prlimit not found; nvlog_decoder runs without address-space, CPU and output-size limits.")
+		return ""
+	}
+	return p
+}()
+
+// cappedBuffer keeps the first and the last max/2 bytes written to it: the start of the output
+// shows what the decoder was given, the end why it stopped. Bytes marks the omitted middle.
+type cappedBuffer struct {
+	max     int
+	head    []byte
+	tail    []byte // last bytes after head, at most max-max/2
+	dropped int64
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.max/2 - len(b.head); room > 0 {
+		k := min(room, len(p))
+		b.head, p = append(b.head, p[:k]...), p[k:]
+	}
+	keep := b.max - b.max/2
+	if len(p) >= keep {
+		b.dropped += int64(len(b.tail) + len(p) - keep)
+		b.tail = append(b.tail[:0], p[len(p)-keep:]...)
+		return n, nil
+	}
+	b.tail = append(b.tail, p...)
+	if over := len(b.tail) - keep; over > 0 {
+		b.dropped += int64(over)
+		b.tail = append(b.tail[:0], b.tail[over:]...)
+	}
+	return n, nil
+}
+
+func (b *cappedBuffer) Bytes() []byte {
+	out := append([]byte{}, b.head...)
+	if b.dropped > 0 {
+		out = fmt.Appendf(out, "\n[... %d bytes omitted ...]\n", b.dropped)
+	}
+	return append(out, b.tail...)
+}
+
+func (b *cappedBuffer) String() string {
+	return string(b.Bytes())
+}
+
+// decoderCommand builds the nvlog_decoder invocation, wrapped in prlimit when available and
+// started through the sandbox helper when sandboxing is active. cleanup must be called once
+// the command has finished.
+func decoderCommand(ctx context.Context, spec sandboxSpec, args ...string) (cmd *exec.Cmd, cleanup func(), err error) {
+	argv := append([]string{"nvlog_decoder"}, args...)
+	if prlimitPath != "" {
+		var limits []string
+		if decoderMaxAddressMB > 0 {
+			limits = append(limits, fmt.Sprintf("--as=%d", int64(decoderMaxAddressMB)<<20))
+		}
+		if decoderMaxCPUSeconds > 0 {
+			// SIGXCPU at the soft limit, SIGKILL shortly after if it is ignored
+			limits = append(limits, fmt.Sprintf("--cpu=%d:%d", decoderMaxCPUSeconds, decoderMaxCPUSeconds+5))
+		}
+		if decoderMaxOutputMB > 0 {
+			limits = append(limits, fmt.Sprintf("--fsize=%d", int64(decoderMaxOutputMB)<<20))
+		}
+		argv = append(append(append([]string{prlimitPath}, limits...), "--"), argv...)
+	}
+	if sandboxTier != "" {
+		return sandboxCommand(ctx, sandboxTier, spec, argv...)
+	}
+	return exec.CommandContext(ctx, argv[0], argv[1:]...), func() {}, nil
+}
+
+// decoderRun describes a finished nvlog_decoder process.
+type decoderRun struct {
+	Output   []byte // stdout and stderr, start and end up to decoderOutputCap in total
+	ExitCode int    // -1 if the decoder was killed by a signal or did not start
+	Duration time.Duration
+}
+
+// runNvlogDecoder runs nvlog_decoder with args and checks outputPath ("" when the decoder only
+// writes to stdout). inputs are the files the decoder reads (visible read-only in the sandbox
+// next to the output directory). Failures are returned as *decodeError with one of the
+// decoder error codes; the run is returned either way.
+func runNvlogDecoder(ctx context.Context, outputPath string, inputs []string, args ...string) (decoderRun, error) {
+	run := decoderRun{ExitCode: -1}
+	runCtx := ctx
+	if decoderTimeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, decoderTimeout)
+		defer cancel()
+	}
+	out := &cappedBuffer{max: decoderOutputCap}
+	spec := sandboxSpec{ReadOnly: inputs}
+	if outputPath != "" {
+		spec.Writable = []string{filepath.Dir(outputPath)}
+	}
+	cmd, cleanup, err := decoderCommand(runCtx, spec, args...)
+	if err != nil {
+		log.Printf("Preparing nvlog_decoder sandbox failed: %v", err)
+		return run, &decodeError{http.StatusInternalServerError, codeDecoderSandbox, "Error: Could not prepare the decoder sandbox."}
+	}
+	defer cleanup()
+	cmd.Stdout = out
+	cmd.Stderr = out
+	// Own process group, so cancellation also kills anything the decoder started
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = 5 * time.Second
+	started := time.Now()
+	err = cmd.Run()
+	run.Output, run.Duration = out.Bytes(), time.Since(started)
+	if cmd.ProcessState != nil {
+		run.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		return run, classifyDecoderFailure(ctx, runCtx, cmd, outputPath, out.String())
+	}
+	if outputPath == "" {
+		return run, nil
+	}
+	// Ensure output file exists and is non-empty; nvlog_decoder might exit 0 but not write output
+	fi, err := os.Stat(outputPath)
+	if err != nil || fi.Size() == 0 {
+		return run, &decodeError{http.StatusInternalServerError, codeDecoderNoOutput, "Error: Decoder produced no output. Please verify input log and ELF mapping."}
+	}
+	if decoderMaxOutputMB > 0 && fi.Size() > int64(decoderMaxOutputMB)<<20 {
+		return run, outputLimitError()
+	}
+	return run, nil
+}
+
+func outputLimitError() error {
+	return &decodeError{http.StatusUnprocessableEntity, codeDecoderOutputLimit, fmt.Sprintf("Error: Decoded output exceeds the %d MB limit.", decoderMaxOutputMB)}
+}
+
+// classifyDecoderFailure maps a failed run to a distinct error: cancellation and wall-clock
+// timeout from the contexts, CPU and output limits from the terminating signal (or CPU time
+// used, output size reached), the address-space limit from the decoder's allocation failure
+// messages.
+func classifyDecoderFailure(ctx, runCtx context.Context, cmd *exec.Cmd, outputPath, output string) error {
+	if ctx.Err() != nil {
+		return &decodeError{http.StatusServiceUnavailable, codeDecodeCancelled, "Decode cancelled."}
+	}
+	if runCtx.Err() == context.DeadlineExceeded {
+		return &decodeError{http.StatusGatewayTimeout, codeDecoderTimeout, fmt.Sprintf("Error: Decoder exceeded the %s time limit.", decoderTimeout)}
+	}
+	cpuLimit := &decodeError{http.StatusGatewayTimeout, codeDecoderCPULimit, fmt.Sprintf("Error: Decoder exceeded the %d s CPU time limit.", decoderMaxCPUSeconds)}
+	if ps := cmd.ProcessState; ps != nil {
+		if sandboxTier != "" && ps.ExitCode() == sandboxSetupExitCode {
+			return &decodeError{http.StatusInternalServerError, codeDecoderSandbox, "Error: Could not set up the decoder sandbox."}
+		}
+		if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			switch ws.Signal() {
+			case syscall.SIGXCPU:
+				return cpuLimit
+			case syscall.SIGXFSZ:
+				return outputLimitError()
+			}
+		}
+		if decoderMaxCPUSeconds > 0 && ps.UserTime()+ps.SystemTime() >= time.Duration(decoderMaxCPUSeconds)*time.Second {
+			return cpuLimit
+		}
+	}
+	// The decoder may ignore SIGXFSZ and fail on EFBIG instead
+	if fi, err := os.Stat(outputPath); err == nil && decoderMaxOutputMB > 0 && fi.Size() >= int64(decoderMaxOutputMB)<<20 {
+		return outputLimitError()
+	}
+	if decoderMaxAddressMB > 0 && isAllocationFailure(output) {
+		return &decodeError{http.StatusUnprocessableEntity, codeDecoderMemoryLimit, fmt.Sprintf("Error: Decoder exceeded the %d MB address-space limit.", decoderMaxAddressMB)}
+	}
+	return &decodeError{http.StatusInternalServerError, codeDecoderFailed, "Error: Log decoder tool failed to run or produced an error."}
+}
+
+// isAllocationFailure recognises nvlog_decoder's messages for failed allocations.
+func isAllocationFailure(output string) bool {
+	o := strings.ToLower(output)
+	for _, m := range []string{"out of memory", "allocation failed", "failed to allocate", "cannot allocate memory", "bad_alloc"} {
+		if strings.Contains(o, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// decoderLimitsSummary is logged at startup.
+func decoderLimitsSummary() string {
+	mb := func(n int) string {
+		if n <= 0 {
+			return "unlimited"
+		}
+		return strconv.Itoa(n) + " MB"
+	}
+	cpu := "unlimited"
+	if decoderMaxCPUSeconds > 0 {
+		cpu = strconv.Itoa(decoderMaxCPUSeconds) + " s"
+	}
+	return fmt.Sprintf("timeout %s, address space %s, CPU %s, output %s, rlimits via prlimit: %t, sandbox: %s",
+		decoderTimeout, mb(decoderMaxAddressMB), cpu, mb(decoderMaxOutputMB), prlimitPath != "", sandboxStatus)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- Decoder worker pool ----------------
+// ------------------------------------------------------------------------------------------------
+
+// At most DECODER_WORKERS decodes (ELF materialisation plus nvlog_decoder) run at once; up to
+// DECODER_QUEUE_SIZE more wait in FIFO order and learn their queue position. Beyond that,
+// requests are rejected with 429 and a Retry-After estimate instead of piling up processes.
+var (
+	decoderWorkers   = parseIntEnv("DECODER_WORKERS", runtime.NumCPU())
+	decoderQueueSize = parseIntEnv("DECODER_QUEUE_SIZE", 32)
+)
+
+var errDecoderBusy = errors.New("decoder queue is full")
+
+// defaultDecodeEstimate seeds Retry-After until the first decode has finished.
+const defaultDecodeEstimate = 5 * time.Second
+
+type decoderWorker struct {
+	busy      bool
+	owner     string
+	busySince time.Time
+	busyTotal time.Duration // finished tasks only
+	tasks     int64
+}
+
+type poolWaiter struct {
+	owner    string
+	ready    chan int // receives the worker index
+	onQueued func(position int)
+}
+
+type decoderPool struct {
+	mu        sync.Mutex
+	workers   []decoderWorker
+	queue     []*poolWaiter
+	maxQueue  int
+	rejected  int64
+	avgTask   time.Duration // moving average of task durations
+	startedAt time.Time
+}
+
+func newDecoderPool(workers, queueSize int) *decoderPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &decoderPool{workers: make([]decoderWorker, workers), maxQueue: queueSize, startedAt: time.Now()}
+}
+
+var decoders = newDecoderPool(decoderWorkers, decoderQueueSize)
+
+// acquire reserves a worker, waiting in the queue if all are busy. onQueued (may be nil) is
+// called with the 1-based queue position on entering the queue and whenever it moves up; it
+// runs under the pool lock, so it must not block. The returned release must be called once
+// the decode has finished.
+func (p *decoderPool) acquire(ctx context.Context, owner string, onQueued func(int)) (release func(), err error) {
+	p.mu.Lock()
+	if len(p.queue) == 0 {
+		for i := range p.workers {
+			if !p.workers[i].busy {
+				p.start(i, owner)
+				p.mu.Unlock()
+				return p.releaser(i), nil
+			}
+		}
+	}
+	if len(p.queue) >= p.maxQueue {
+		p.rejected++
+		p.mu.Unlock()
+		return nil, errDecoderBusy
+	}
+	w := &poolWaiter{owner: owner, ready: make(chan int, 1), onQueued: onQueued}
+	p.queue = append(p.queue, w)
+	if onQueued != nil {
+		onQueued(len(p.queue))
+	}
+	p.mu.Unlock()
+
+	select {
+	case i := <-w.ready:
+		return p.releaser(i), nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		for idx, q := range p.queue {
+			if q == w {
+				p.queue = append(p.queue[:idx], p.queue[idx+1:]...)
+				p.notifyPositions(idx)
+				p.mu.Unlock()
+				return nil, ctx.Err()
+			}
+		}
+		p.mu.Unlock()
+		// A worker was handed over at the same time; give it back
+		p.release(<-w.ready)
+		return nil, ctx.Err()
+	}
+}
+
+func (p *decoderPool) start(i int, owner string) {
+	p.workers[i].busy = true
+	p.workers[i].owner = owner
+	p.workers[i].busySince = time.Now()
+}
+
+func (p *decoderPool) releaser(i int) func() {
+	var once sync.Once
+	return func() { once.Do(func() { p.release(i) }) }
+}
+
+func (p *decoderPool) release(i int) {
+	p.mu.Lock()
+	w := &p.workers[i]
+	d := time.Since(w.busySince)
+	w.busy = false
+	w.owner = ""
+	w.busyTotal += d
+	w.tasks++
+	if p.avgTask == 0 {
+		p.avgTask = d
+	} else {
+		p.avgTask = (p.avgTask*4 + d) / 5
+	}
+	if len(p.queue) > 0 {
+		next := p.queue[0]
+		p.queue = p.queue[1:]
+		p.start(i, next.owner)
+		next.ready <- i
+		p.notifyPositions(0)
+	}
+	p.mu.Unlock()
+}
+
+// notifyPositions tells the waiters from index from on their new queue position.
+func (p *decoderPool) notifyPositions(from int) {
+	for idx := from; idx < len(p.queue); idx++ {
+		if p.queue[idx].onQueued != nil {
+			p.queue[idx].onQueued(idx + 1)
+		}
+	}
+}
+
+// full reports whether a new request would be rejected right now.
+func (p *decoderPool) full() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.queue) < p.maxQueue {
+		return false
+	}
+	for i := range p.workers {
+		if !p.workers[i].busy {
+			return false
+		}
+	}
+	return true
+}
+
+// retryAfter estimates in seconds when the queue will have room again.
+func (p *decoderPool) retryAfter() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	avg := p.avgTask
+	if avg == 0 {
+		avg = defaultDecodeEstimate
+	}
+	wait := avg * time.Duration(len(p.queue)/len(p.workers)+1)
+	if s := int((wait + time.Second - 1) / time.Second); s > 1 {
+		return s
+	}
+	return 1
+}
+
+// setRetryAfter adds the Retry-After header to a 429 caused by a full decoder queue.
+func setRetryAfter(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(decoders.retryAfter()))
+}
+
+// DecoderWorkerStats describes one worker of the pool.
+type DecoderWorkerStats struct {
+	Index       int     `json:"index"`
+	Busy        bool    `json:"busy"`
+	Owner       string  `json:"owner,omitempty"`     // user of the running decode
+	BusyForMs   int64   `json:"busyForMs,omitempty"` // running decode so far
+	BusyTotalMs int64   `json:"busyTotalMs"`         // includes the running decode
+	Tasks       int64   `json:"tasks"`               // finished decodes
+	Utilization float64 `json:"utilization"`         // busy share of the uptime, 0..1
+}
+
+// DecoderPoolStats is returned by GET /api/admin/decoder-pool.
+type DecoderPoolStats struct {
+	Success     bool                 `json:"success"`
+	Workers     int                  `json:"workers"`
+	Busy        int                  `json:"busy"`
+	QueueSize   int                  `json:"queueSize"`
+	Queued      int                  `json:"queued"`
+	Rejected    int64                `json:"rejected"`
+	AvgTaskMs   int64                `json:"avgTaskMs"`
+	UptimeMs    int64                `json:"uptimeMs"`
+	Sandbox     string               `json:"sandbox"` // nvlog_decoder sandbox status
+	WorkerStats []DecoderWorkerStats `json:"workerStats"`
+}
+
+func (p *decoderPool) stats() DecoderPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	uptime := now.Sub(p.startedAt)
+	s := DecoderPoolStats{
+		Success:     true,
+		Workers:     len(p.workers),
+		QueueSize:   p.maxQueue,
+		Queued:      len(p.queue),
+		Rejected:    p.rejected,
+		AvgTaskMs:   p.avgTask.Milliseconds(),
+		UptimeMs:    uptime.Milliseconds(),
+		Sandbox:     sandboxStatus,
+		WorkerStats: make([]DecoderWorkerStats, len(p.workers)),
+	}
+	for i, w := range p.workers {
+		busyTotal := w.busyTotal
+		ws := DecoderWorkerStats{Index: i, Busy: w.busy, Owner: w.owner, Tasks: w.tasks}
+		if w.busy {
+			s.Busy++
+			ws.BusyForMs = now.Sub(w.busySince).Milliseconds()
+			busyTotal += now.Sub(w.busySince)
+		}
+		ws.BusyTotalMs = busyTotal.Milliseconds()
+		if uptime > 0 {
+			ws.Utilization = float64(busyTotal) / float64(uptime)
+		}
+		s.WorkerStats[i] = ws
+	}
+	return s
+}
+
+// adminDecoderPoolHandler reports worker utilization and queue state for the admin dashboard.
+func adminDecoderPoolHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Only GET requests are supported.", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(decoders.stats())
+}
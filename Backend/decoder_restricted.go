@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- nvlog_decoder restricted sandbox (seccomp + Landlock) ----------------
+// ------------------------------------------------------------------------------------------------
+
+// The restricted tier needs neither namespaces nor a relaxed container profile: the helper sets
+// no_new_privs, limits the filesystem with Landlock when the kernel supports it, installs a
+// seccomp filter and execs the decoder, which inherits both. The helper keeps its pid through
+// the exec, which the filter uses to allow signals to the decoder's own process group only.
+
+// seccompArch describes the syscall ABI the filter is built for. Both supported architectures
+// are little-endian, so the low word of an argument comes first in seccomp_data.
+type seccompArch struct {
+	audit                      uint32 // AUDIT_ARCH_*
+	x32                        bool   // amd64: reject the x32 ABI (syscall bit 30)
+	bpf, userfaultfd           uint32 // syscall numbers not in package syscall
+	processVMRead, processVMWr uint32
+}
+
+var seccompArchs = map[string]seccompArch{
+	"amd64": {audit: 0xc000003e, x32: true, bpf: 321, userfaultfd: 323, processVMRead: 310, processVMWr: 311},
+	"arm64": {audit: 0xc00000b7, bpf: 280, userfaultfd: 282, processVMRead: 270, processVMWr: 271},
+}
+
+// Syscall numbers shared by all architectures (asm-generic unified numbering).
+const (
+	sysPidfdSendSignal       = 424
+	sysIOUringSetup          = 425
+	sysIOUringEnter          = 426
+	sysIOUringRegister       = 427
+	sysPidfdOpen             = 434
+	sysPidfdGetfd            = 438
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+)
+
+// seccomp and classic BPF constants.
+const (
+	prSetSeccomp          = 22
+	seccompModeFilter     = 2
+	bpfLdWAbs             = 0x20 // BPF_LD | BPF_W | BPF_ABS
+	bpfJeqK               = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+	bpfJgeK               = 0x35 // BPF_JMP | BPF_JGE | BPF_K
+	bpfRetK               = 0x06 // BPF_RET | BPF_K
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetAllow       = 0x7fff0000
+	seccompDataNr         = 0
+	seccompDataArch       = 4
+	seccompDataArg0       = 16
+)
+
+// Landlock constants (ABI 1 to 3).
+const (
+	landlockCreateRulesetVersion = 1
+	landlockRulePathBeneath      = 1
+	llExecute                    = 1 << 0
+	llWriteFile                  = 1 << 1
+	llReadFile                   = 1 << 2
+	llReadDir                    = 1 << 3
+	llRefer                      = 1 << 13 // ABI 2
+	llTruncate                   = 1 << 14 // ABI 3
+	llFileRights                 = llExecute | llWriteFile | llReadFile | llTruncate
+	oPath                        = 0x200000
+)
+
+type sockFilter struct {
+	code   uint16
+	jt, jf uint8
+	k      uint32
+}
+
+type sockFprog struct {
+	len    uint16
+	filter *sockFilter
+}
+
+// enterRestrictedSandbox confines the helper thread; the decoder it execs inherits the limits.
+func enterRestrictedSandbox(ro, rw []string) error {
+	// Only relevant if the backend runs as root: keep no capability across the exec
+	dropBoundingCapabilities()
+	if err := clearCapabilities(); err != nil {
+		return err
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("no_new_privs: %w", errno)
+	}
+	if abi := landlockABI(); abi > 0 {
+		if err := restrictFilesystem(abi, ro, rw); err != nil {
+			return fmt.Errorf("landlock: %w", err)
+		}
+	}
+	filter, err := seccompFilter(os.Getpid())
+	if err != nil {
+		return err
+	}
+	prog := sockFprog{len: uint16(len(filter)), filter: &filter[0]}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetSeccomp, seccompModeFilter, uintptr(unsafe.Pointer(&prog)))
+	runtime.KeepAlive(filter)
+	if errno != 0 {
+		return fmt.Errorf("install seccomp filter: %w", errno)
+	}
+	return nil
+}
+
+// seccompFilter builds the filter for a decoder running as pid self: no sockets but AF_UNIX, no
+// tracing or reading other processes' memory, signals only to itself or its process group
+// (pgid self), and none of the kernel interfaces commonly used to escalate. Everything else is
+// allowed; denied calls fail with EPERM.
+func seccompFilter(self int) ([]sockFilter, error) {
+	arch, ok := seccompArchs[runtime.GOARCH]
+	if !ok {
+		return nil, fmt.Errorf("no seccomp filter for %s", runtime.GOARCH)
+	}
+	stmt := func(code uint16, k uint32) sockFilter { return sockFilter{code: code, k: k} }
+	jump := func(code uint16, k uint32, jt, jf uint8) sockFilter {
+		return sockFilter{code: code, jt: jt, jf: jf, k: k}
+	}
+	deny := stmt(bpfRetK, seccompRetErrno|uint32(syscall.EPERM))
+	allow := stmt(bpfRetK, seccompRetAllow)
+
+	f := []sockFilter{
+		stmt(bpfLdWAbs, seccompDataArch),
+		jump(bpfJeqK, arch.audit, 1, 0),
+		stmt(bpfRetK, seccompRetKillProcess),
+		stmt(bpfLdWAbs, seccompDataNr),
+	}
+	if arch.x32 {
+		f = append(f, jump(bpfJgeK, 0x40000000, 0, 1), stmt(bpfRetK, seccompRetKillProcess))
+	}
+	for _, nr := range []uint32{
+		syscall.SYS_PTRACE, arch.processVMRead, arch.processVMWr, syscall.SYS_TKILL,
+		sysPidfdOpen, sysPidfdSendSignal, sysPidfdGetfd,
+		sysIOUringSetup, sysIOUringEnter, sysIOUringRegister,
+		arch.bpf, syscall.SYS_PERF_EVENT_OPEN, arch.userfaultfd,
+		syscall.SYS_KEYCTL, syscall.SYS_ADD_KEY, syscall.SYS_REQUEST_KEY,
+		syscall.SYS_MOUNT, syscall.SYS_UMOUNT2, syscall.SYS_PIVOT_ROOT, syscall.SYS_KEXEC_LOAD,
+	} {
+		f = append(f, jump(bpfJeqK, nr, 0, 1), deny)
+	}
+	// socket(domain, ...): AF_UNIX only
+	f = append(f,
+		jump(bpfJeqK, syscall.SYS_SOCKET, 0, 4),
+		stmt(bpfLdWAbs, seccompDataArg0),
+		jump(bpfJeqK, syscall.AF_UNIX, 0, 1),
+		allow,
+		deny,
+	)
+	// The first argument of these is a pid_t: self, 0 (own group) or -self (group self)
+	for _, nr := range []uint32{syscall.SYS_KILL, syscall.SYS_TGKILL, syscall.SYS_RT_SIGQUEUEINFO, syscall.SYS_RT_TGSIGQUEUEINFO} {
+		f = append(f,
+			jump(bpfJeqK, nr, 0, 6),
+			stmt(bpfLdWAbs, seccompDataArg0),
+			jump(bpfJeqK, uint32(self), 3, 0),
+			jump(bpfJeqK, 0, 2, 0),
+			jump(bpfJeqK, uint32(-int32(self)), 1, 0),
+			deny,
+			allow,
+		)
+	}
+	return append(f, allow), nil
+}
+
+// landlockABI returns the kernel's Landlock ABI version, 0 if Landlock is unavailable or
+// blocked (e.g. by a container's seccomp profile).
+func landlockABI() int {
+	v, _, errno := syscall.RawSyscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return 0
+	}
+	return int(v)
+}
+
+// restrictFilesystem limits the calling thread to executing and reading the system libraries,
+// reading the inputs and /etc/ld.so.cache, using the /dev nodes and anything inside the
+// writable directories.
+func restrictFilesystem(abi int, ro, rw []string) error {
+	handled := uint64(1<<13 - 1)
+	if abi >= 2 {
+		handled |= llRefer
+	}
+	if abi >= 3 {
+		handled |= llTruncate
+	}
+	attr := handled // struct landlock_ruleset_attr, handled_access_fs only
+	fd, _, errno := syscall.RawSyscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("create ruleset: %w", errno)
+	}
+	ruleset := int(fd)
+	defer syscall.Close(ruleset)
+
+	allowPath := func(path string, access uint64) error {
+		pfd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return err
+		}
+		defer syscall.Close(pfd)
+		var st syscall.Stat_t
+		if err := syscall.Fstat(pfd, &st); err != nil {
+			return err
+		}
+		if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+			access &= llFileRights
+		}
+		// struct landlock_path_beneath_attr is packed: u64 allowed_access, s32 parent_fd
+		var rule [12]byte
+		binary.LittleEndian.PutUint64(rule[0:], access&handled)
+		binary.LittleEndian.PutUint32(rule[8:], uint32(pfd))
+		if _, _, errno := syscall.RawSyscall6(sysLandlockAddRule, uintptr(ruleset), landlockRulePathBeneath, uintptr(unsafe.Pointer(&rule[0])), 0, 0, 0); errno != 0 {
+			return fmt.Errorf("add rule for %s: %w", path, errno)
+		}
+		return nil
+	}
+	optional := func(err error) error {
+		if errors.Is(err, syscall.ENOENT) {
+			return nil
+		}
+		return err
+	}
+	for _, p := range []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32"} {
+		if err := optional(allowPath(p, llExecute|llReadFile|llReadDir)); err != nil {
+			return err
+		}
+	}
+	if err := optional(allowPath("/etc/ld.so.cache", llReadFile)); err != nil {
+		return err
+	}
+	for _, d := range []string{"/dev/null", "/dev/zero", "/dev/random", "/dev/urandom"} {
+		if err := allowPath(d, llReadFile|llWriteFile|llTruncate); err != nil {
+			return err
+		}
+	}
+	for _, f := range ro {
+		if err := allowPath(f, llReadFile); err != nil {
+			return err
+		}
+	}
+	for _, d := range rw {
+		if err := allowPath(d, handled); err != nil {
+			return err
+		}
+	}
+	if _, _, errno := syscall.RawSyscall(sysLandlockRestrictSelf, uintptr(ruleset), 0, 0); errno != 0 {
+		return fmt.Errorf("restrict self: %w", errno)
+	}
+	return nil
+}
+
+// probeRestricted checks the restricted tier: no network sockets, no signals to the backend
+// and, with Landlock, no access to other files.
+func probeRestricted() error {
+	if fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0); err == nil {
+		syscall.Close(fd)
+		return errors.New("network sockets are allowed")
+	}
+	if err := syscall.Kill(os.Getppid(), 0); err != syscall.EPERM {
+		return fmt.Errorf("signalling the backend is not denied (%v)", err)
+	}
+	if landlockABI() > 0 {
+		if _, err := os.ReadFile("/etc/passwd"); err == nil {
+			return errors.New("host path /etc/passwd is readable")
+		}
+	}
+	return nil
+}
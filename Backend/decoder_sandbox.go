@@ -0,0 +1,729 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- nvlog_decoder sandbox ----------------
+// ------------------------------------------------------------------------------------------------
+
+// nvlog_decoder is a third-party binary (it links libcurl), so it is started through a small
+// helper: the backend binary re-executed with sandboxHelperArg. The helper confines itself and
+// then execs the decoder (via prlimit). There are two tiers, tried strongest first:
+//
+//   - namespaces: the helper starts itself again in new user, mount, PID, network, IPC and UTS
+//     namespaces. newuidmap/newgidmap map root of the user namespace to the backend user and
+//     uid/gid 1 to the decoder account (DECODER_SANDBOX_UID/GID, dcedecoder in the image), which
+//     owns nothing. The inner helper builds a tmpfs root holding only the system libraries, the
+//     inputs (read-only) and the output directory, pivots into it and switches to the decoder
+//     account without capabilities.
+//   - restricted: for hosts that refuse unprivileged namespaces, e.g. under Docker's default
+//     seccomp and AppArmor profiles. The decoder keeps the backend's uid, but a seccomp filter
+//     denies it network sockets, ptrace and signals to other processes, and Landlock (where the
+//     kernel has it) limits the filesystem to the system libraries, the inputs and the output
+//     directory. The backend makes itself non-dumpable so /proc does not expose its memory or
+//     environment.
+//
+// DECODER_SANDBOX selects the mode:
+//   - auto (default): the strongest tier whose startup self-check passes, otherwise warn and run
+//     unsandboxed
+//   - required: like auto, but refuse to start if no tier passes
+//   - namespaces: refuse to start unless the namespaces tier passes
+//   - off: run the decoder directly as the backend user
+var (
+	decoderSandboxMode = strings.ToLower(getenv("DECODER_SANDBOX", "auto"))
+	decoderSandboxUID  = parseIntEnv("DECODER_SANDBOX_UID", 10002)
+	decoderSandboxGID  = parseIntEnv("DECODER_SANDBOX_GID", 10002)
+)
+
+// Sandbox tiers (sandboxTier).
+const (
+	sandboxTierNamespaces = "namespaces"
+	sandboxTierRestricted = "restricted"
+)
+
+const (
+	sandboxHelperArg = "__nvlog-sandbox"
+	// sandboxSetupExitCode is the helper's exit status when the sandbox could not be built
+	sandboxSetupExitCode = 125
+	sandboxCloneflags    = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	sandboxPath          = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	// sandboxDecoderID is the decoder account's uid and gid inside the user namespace
+	sandboxDecoderID = 1
+	// sandboxProbeFile is written by the probe into each writable directory; its owner tells
+	// the backend which account the decoder runs as
+	sandboxProbeFile = ".sandbox-probe"
+)
+
+// prctl(2) and capability constants not in package syscall.
+const (
+	prSetPdeathsig   = 1
+	prSetDumpable    = 4
+	prCapbsetDrop    = 24
+	prSetNoNewPrivs  = 38
+	linuxCapVersion3 = 0x20080522
+)
+
+// sandboxTier is the tier decodes run in, "" when unsandboxed; set once by initDecoderSandbox.
+var (
+	sandboxTier   string
+	sandboxStatus = "not checked"
+)
+
+// sandboxSpec lists what the decoder may see besides the system libraries.
+type sandboxSpec struct {
+	ReadOnly []string // files, e.g. the input log and the ELF
+	Writable []string // directories, e.g. the output directory
+}
+
+// initDecoderSandbox runs the startup self-checks and picks the tier decodes run in. With
+// DECODER_SANDBOX=required or namespaces, a failed check is fatal.
+func initDecoderSandbox() {
+	tiers := []string{sandboxTierNamespaces, sandboxTierRestricted}
+	switch decoderSandboxMode {
+	case "off", "false", "0":
+		sandboxStatus = "disabled (DECODER_SANDBOX=off)"
+		log.Printf("WARNING: nvlog_decoder sandbox disabled; the decoder runs as the backend user with full filesystem and network access.")
+		markBackendNonDumpable()
+		return
+	case "auto", "required":
+	case sandboxTierNamespaces:
+		tiers = tiers[:1]
+	default:
+		log.Fatalf("Invalid DECODER_SANDBOX %q (expected auto, required, namespaces or off)", decoderSandboxMode)
+	}
+	var failures []string
+	for _, tier := range tiers {
+		uid, err := sandboxSelfCheck(tier)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", tier, err))
+			continue
+		}
+		if len(failures) > 0 {
+			log.Printf("nvlog_decoder sandbox tier unavailable: %s", strings.Join(failures, "; "))
+		}
+		sandboxTier = tier
+		sandboxStatus = sandboxTierStatus(tier, uid)
+		if tier == sandboxTierRestricted {
+			markBackendNonDumpable()
+		}
+		log.Printf("nvlog_decoder sandbox %s.", sandboxStatus)
+		return
+	}
+	msg := strings.Join(failures, "; ")
+	sandboxStatus = "inactive: " + msg
+	if decoderSandboxMode != "auto" {
+		log.Fatalf("nvlog_decoder sandbox self-check failed (DECODER_SANDBOX=%s): %s", decoderSandboxMode, msg)
+	}
+	log.Printf("WARNING: nvlog_decoder sandbox self-check failed, running the decoder unsandboxed: %s", msg)
+	markBackendNonDumpable()
+}
+
+// sandboxTierStatus describes an active tier for the startup log and /api/decoder/status.
+func sandboxTierStatus(tier string, uid int) string {
+	if tier == sandboxTierNamespaces {
+		return fmt.Sprintf("namespaces (decoder uid %d gid %d, backend uid %d; no capabilities; user/mnt/pid/net/ipc/uts namespaces)",
+			uid, decoderSandboxGID, os.Getuid())
+	}
+	fs := "filesystem not restricted, Landlock unavailable"
+	if abi := landlockABI(); abi > 0 {
+		fs = fmt.Sprintf("Landlock ABI %d: filesystem limited to system libraries, inputs and output", abi)
+	}
+	return fmt.Sprintf("restricted (decoder uid %d, shared with the backend; seccomp: no network, ptrace or signals to other processes; %s)", uid, fs)
+}
+
+// markBackendNonDumpable keeps a decoder running under the backend's uid from attaching to the
+// backend or reading its memory and environment through /proc.
+func markBackendNonDumpable() {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetDumpable, 0, 0); errno != 0 {
+		log.Printf("WARNING: Could not mark the backend non-dumpable: %v", errno)
+	}
+}
+
+// sandboxSelfCheck starts the helper of tier in probe mode on a scratch input and output
+// directory and returns the uid the probe ran as, taken from the file it wrote.
+func sandboxSelfCheck(tier string) (uid int, err error) {
+	dir, err := os.MkdirTemp("", "dce-sandbox-check-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in.log")
+	out := filepath.Join(dir, "out")
+	if err := os.WriteFile(in, []byte("probe"), 0644); err != nil {
+		return 0, err
+	}
+	if err := os.Mkdir(out, 0700); err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd, cleanup, err := sandboxCommand(ctx, tier, sandboxSpec{ReadOnly: []string{in}, Writable: []string{out}}, "-probe")
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup()
+	if output, err := cmd.CombinedOutput(); err != nil {
+		msg := strings.TrimSpace(string(output))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return 0, errors.New(msg)
+	}
+	// The backend must be able to read what the decoder writes
+	probe := filepath.Join(out, sandboxProbeFile)
+	if data, err := os.ReadFile(probe); err != nil || string(data) != "ok" {
+		return 0, fmt.Errorf("probe output not readable by the backend: %v", err)
+	}
+	fi, err := os.Stat(probe)
+	if err != nil {
+		return 0, err
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	uid = int(st.Uid)
+	if tier == sandboxTierNamespaces && (uid != decoderSandboxUID || int(st.Gid) != decoderSandboxGID) {
+		return 0, fmt.Errorf("decoder ran as uid %d gid %d, expected %d:%d", uid, st.Gid, decoderSandboxUID, decoderSandboxGID)
+	}
+	return uid, nil
+}
+
+// sandboxCommand returns the helper invocation that runs argv (or "-probe") in a sandbox of
+// tier built from spec. cleanup removes the scratch mount point once the command has finished.
+func sandboxCommand(ctx context.Context, tier string, spec sandboxSpec, argv ...string) (cmd *exec.Cmd, cleanup func(), err error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, nil, err
+	}
+	args := []string{sandboxHelperArg, "-tier", tier}
+	cleanup = func() {}
+	if tier == sandboxTierNamespaces {
+		if err := grantDecoderAccess(spec); err != nil {
+			return nil, nil, err
+		}
+		root, err := os.MkdirTemp("", "dce-sandbox-root-")
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, "-root", root)
+		cleanup = func() { os.Remove(root) }
+	}
+	for _, p := range spec.ReadOnly {
+		args = append(args, "-ro", p)
+	}
+	for _, d := range spec.Writable {
+		args = append(args, "-rw", d)
+	}
+	if len(argv) == 1 && argv[0] == "-probe" {
+		args = append(args, "-probe")
+	} else {
+		args = append(append(args, "--"), argv...)
+	}
+	cmd = exec.CommandContext(ctx, self, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		// Own process group: the restricted tier lets the decoder signal its group only
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+	return cmd, cleanup, nil
+}
+
+// grantDecoderAccess lets the decoder account read the inputs and write into the output
+// directories. The directories are sticky, so the decoder cannot remove the backend's files.
+func grantDecoderAccess(spec sandboxSpec) error {
+	if decoderSandboxUID <= 0 || decoderSandboxUID == os.Getuid() || decoderSandboxGID <= 0 || decoderSandboxGID == os.Getgid() {
+		return fmt.Errorf("DECODER_SANDBOX_UID/GID (%d:%d) must be a dedicated account, not root or the backend's %d:%d",
+			decoderSandboxUID, decoderSandboxGID, os.Getuid(), os.Getgid())
+	}
+	for _, p := range spec.ReadOnly {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(p, fi.Mode().Perm()|0444); err != nil {
+			return err
+		}
+	}
+	for _, d := range spec.Writable {
+		if err := os.Chmod(d, 0777|os.ModeSticky); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ---------------- Helper side ----------------
+
+// sandboxHelperArgs are the parsed arguments of the re-executed helper.
+type sandboxHelperArgs struct {
+	tier   string
+	root   string // namespaces tier: scratch directory for the new root
+	ro, rw []string
+	argv   []string
+	probe  bool
+	inner  bool // namespaces tier: already inside the new namespaces
+	mapped bool // namespaces tier: re-executed as root of the mapped user namespace
+}
+
+func parseSandboxHelperArgs(args []string) sandboxHelperArgs {
+	var a sandboxHelperArgs
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--":
+			a.argv = args[i+1:]
+			i = len(args)
+		case arg == "-probe":
+			a.probe = true
+		case arg == "-inner":
+			a.inner = true
+		case arg == "-mapped":
+			a.mapped = true
+		case i+1 < len(args):
+			v := args[i+1]
+			i++
+			switch arg {
+			case "-tier":
+				a.tier = v
+			case "-root":
+				a.root = v
+			case "-ro":
+				a.ro = append(a.ro, v)
+			case "-rw":
+				a.rw = append(a.rw, v)
+			}
+		}
+	}
+	return a
+}
+
+// runSandboxHelper is main() for the re-executed helper. It never returns.
+func runSandboxHelper(args []string) {
+	// Capabilities, seccomp filters and Landlock domains are per thread: confine the thread that
+	// execs the decoder. It is also the parent thread Pdeathsig refers to.
+	runtime.LockOSThread()
+	a := parseSandboxHelperArgs(args)
+	fail := func(format string, v ...interface{}) {
+		fmt.Fprintf(os.Stderr, "sandbox: "+format+"\n", v...)
+		os.Exit(sandboxSetupExitCode)
+	}
+	if !a.probe && len(a.argv) == 0 {
+		fail("invalid helper arguments")
+	}
+	env := []string{"PATH=" + sandboxPath, "HOME=/tmp"}
+	switch {
+	case a.tier == sandboxTierNamespaces && !a.inner:
+		if err := relayNamespaceSandbox(args); err != nil {
+			fail("%v", err)
+		}
+	case a.tier == sandboxTierNamespaces && !a.mapped:
+		if err := awaitIDMapping(); err != nil {
+			fail("%v", err)
+		}
+		// This helper was exec'd before its uid was mapped, which cost it the capabilities of
+		// the user namespace; exec again as the namespace's root
+		err := syscall.Exec("/proc/self/exe", append([]string{os.Args[0], sandboxHelperArg, "-mapped"}, args...), os.Environ())
+		fail("re-exec: %v", err)
+	case a.tier == sandboxTierNamespaces:
+		if a.root == "" {
+			fail("invalid helper arguments")
+		}
+		if err := buildSandboxRoot(a.root, a.ro, a.rw); err != nil {
+			fail("%v", err)
+		}
+		if err := switchToDecoderAccount(); err != nil {
+			fail("%v", err)
+		}
+	case a.tier == sandboxTierRestricted:
+		if err := enterRestrictedSandbox(a.ro, a.rw); err != nil {
+			fail("%v", err)
+		}
+		// No /tmp under Landlock; scratch files go to the output directory
+		home := "/nonexistent"
+		if len(a.rw) > 0 {
+			home = a.rw[0]
+		}
+		env = []string{"PATH=" + sandboxPath, "HOME=" + home, "TMPDIR=" + home}
+	default:
+		fail("unknown sandbox tier %q", a.tier)
+	}
+	// Output must stay readable by the backend
+	syscall.Umask(022)
+	if len(a.rw) > 0 {
+		os.Chdir(a.rw[0])
+	}
+	if a.probe {
+		if err := probeSandbox(a.tier, a.ro, a.rw); err != nil {
+			fail("%v", err)
+		}
+		os.Exit(0)
+	}
+	os.Setenv("PATH", sandboxPath)
+	path, err := exec.LookPath(a.argv[0])
+	if err != nil {
+		fail("%v", err)
+	}
+	err = syscall.Exec(path, a.argv, env)
+	fail("exec %s: %v", a.argv[0], err)
+}
+
+// relayNamespaceSandbox starts the inner helper in new namespaces, maps its user namespace and
+// exits with the inner helper's status, re-raising a terminating signal so the backend sees
+// SIGXCPU/SIGXFSZ as before. It returns only on setup errors.
+func relayNamespaceSandbox(args []string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(self, append([]string{sandboxHelperArg, "-inner"}, args...)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{r} // fd 3: closed after the ID mapping is written
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: sandboxCloneflags, Pdeathsig: syscall.SIGKILL}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("create namespaces: %w", err)
+	}
+	r.Close()
+	if err := mapSandboxIDs(cmd.Process.Pid); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	w.Write([]byte{1})
+	w.Close()
+	cmd.Wait()
+	ws, _ := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if ws.Signaled() {
+		raiseSignal(ws.Signal())
+	}
+	os.Exit(ws.ExitStatus())
+	return nil
+}
+
+// mapSandboxIDs maps root of pid's user namespace to the backend user and sandboxDecoderID to
+// the decoder account. An unprivileged backend needs newuidmap/newgidmap and a subordinate
+// id entry for the decoder account (/etc/subuid, /etc/subgid).
+func mapSandboxIDs(pid int) error {
+	maps := []struct {
+		file, tool string
+		entries    [2]string // "inside outside count"
+	}{
+		{"uid_map", "newuidmap", [2]string{fmt.Sprintf("0 %d 1", os.Getuid()), fmt.Sprintf("%d %d 1", sandboxDecoderID, decoderSandboxUID)}},
+		{"gid_map", "newgidmap", [2]string{fmt.Sprintf("0 %d 1", os.Getgid()), fmt.Sprintf("%d %d 1", sandboxDecoderID, decoderSandboxGID)}},
+	}
+	for _, m := range maps {
+		if os.Geteuid() == 0 {
+			table := m.entries[0] + "\n" + m.entries[1] + "\n"
+			if err := os.WriteFile(fmt.Sprintf("/proc/%d/%s", pid, m.file), []byte(table), 0); err != nil {
+				return fmt.Errorf("write %s: %w", m.file, err)
+			}
+			continue
+		}
+		args := append([]string{strconv.Itoa(pid)}, strings.Fields(m.entries[0]+" "+m.entries[1])...)
+		out, err := exec.Command(m.tool, args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %v: %s", m.tool, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// raiseSignal terminates the process with sig under its default action; Go ignores some
+// signals (SIGXCPU, SIGXFSZ) that it has not been asked to notify.
+func raiseSignal(sig syscall.Signal) {
+	var act [4]uintptr // struct sigaction: SIG_DFL, no flags, restorer or mask
+	syscall.RawSyscall6(syscall.SYS_RT_SIGACTION, uintptr(sig), uintptr(unsafe.Pointer(&act[0])), 0, 8, 0, 0)
+	syscall.Setrlimit(syscall.RLIMIT_CORE, &syscall.Rlimit{})
+	syscall.Kill(os.Getpid(), sig)
+	time.Sleep(time.Second)
+	os.Exit(128 + int(sig))
+}
+
+// awaitIDMapping blocks until the relaying helper has mapped the user namespace.
+func awaitIDMapping() error {
+	f := os.NewFile(3, "sandbox-sync")
+	defer f.Close()
+	if n, _ := f.Read(make([]byte, 1)); n != 1 {
+		return errors.New("user namespace was not mapped")
+	}
+	return nil
+}
+
+// buildSandboxRoot mounts a tmpfs at root with the system directories, /dev nodes, /proc, a
+// private /tmp and the given files and directories, then pivots into it.
+func buildSandboxRoot(root string, ro, rw []string) error {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+	if err := syscall.Mount("tmpfs", root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755,size=16m"); err != nil {
+		return fmt.Errorf("mount root tmpfs: %w", err)
+	}
+	// System directories (read-only); on merged-/usr systems /bin, /lib... are symlinks
+	for _, p := range []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32"} {
+		fi, err := os.Lstat(p)
+		switch {
+		case err != nil:
+			continue
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(target, filepath.Join(root, p)); err != nil {
+				return err
+			}
+		case fi.IsDir():
+			if err := bindMount(p, filepath.Join(root, p), true); err != nil {
+				return err
+			}
+		}
+	}
+	if err := bindMount("/etc/ld.so.cache", filepath.Join(root, "/etc/ld.so.cache"), true); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, d := range []string{"/dev/null", "/dev/zero", "/dev/random", "/dev/urandom"} {
+		if err := bindMount(d, filepath.Join(root, d), false); err != nil {
+			return err
+		}
+	}
+	// /tmp first: the work directories usually live below it
+	tmp := filepath.Join(root, "/tmp")
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", tmp, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777,size=64m"); err != nil {
+		return fmt.Errorf("mount /tmp: %w", err)
+	}
+	for _, d := range rw {
+		if err := bindMount(d, filepath.Join(root, d), false); err != nil {
+			return err
+		}
+	}
+	for _, f := range ro {
+		if err := bindMount(f, filepath.Join(root, f), true); err != nil {
+			return err
+		}
+	}
+	proc := filepath.Join(root, "/proc")
+	if err := os.MkdirAll(proc, 0555); err != nil {
+		return err
+	}
+	// A user namespace may not mount proc over a partly masked one (e.g. in Docker); the
+	// decoder does without
+	syscall.Mount("proc", proc, "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+
+	old := filepath.Join(root, ".oldroot")
+	if err := os.Mkdir(old, 0700); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(root, old); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+	if err := syscall.Unmount("/.oldroot", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("detach old root: %w", err)
+	}
+	os.Remove("/.oldroot")
+	if err := syscall.Mount("", "/", "", syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("remount root read-only: %w", err)
+	}
+	return nil
+}
+
+// bindMount bind-mounts src (file or directory) at dst inside the sandbox root, creating the
+// mount point. Bind mounts are nosuid and, unless they are device nodes, nodev; flags of the
+// source mount that the user namespace may not clear are kept.
+func bindMount(src, dst string, readOnly bool) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		err = os.MkdirAll(dst, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(dst), 0755); err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(dst, os.O_CREATE|os.O_RDONLY, 0644); err == nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := syscall.Mount(src, dst, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind %s: %w", src, err)
+	}
+	flags := uintptr(syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_NOSUID) | lockedMountFlags(src)
+	if fi.Mode()&os.ModeDevice == 0 {
+		flags |= syscall.MS_NODEV
+	}
+	if readOnly {
+		flags |= syscall.MS_RDONLY
+	}
+	if err := syscall.Mount("", dst, "", flags, ""); err != nil {
+		return fmt.Errorf("remount %s: %w", src, err)
+	}
+	return nil
+}
+
+// lockedMountFlags returns the flags of the mount holding path that a remount inside the user
+// namespace has to repeat.
+func lockedMountFlags(path string) uintptr {
+	var st syscall.Statfs_t
+	if syscall.Statfs(path, &st) != nil {
+		return 0
+	}
+	var flags uintptr
+	for _, f := range []struct{ st, ms uintptr }{
+		{1, syscall.MS_RDONLY}, {2, syscall.MS_NOSUID}, {4, syscall.MS_NODEV}, {8, syscall.MS_NOEXEC},
+		{1024, syscall.MS_NOATIME}, {2048, syscall.MS_NODIRATIME}, {4096, syscall.MS_RELATIME},
+	} {
+		if uintptr(st.Flags)&f.st != 0 {
+			flags |= f.ms
+		}
+	}
+	return flags
+}
+
+// capHeader and capData are the arguments of capget(2)/capset(2), version 3.
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective, permitted, inheritable uint32
+}
+
+// switchToDecoderAccount turns the inner helper, root of its user namespace, into the decoder
+// account without supplementary groups or capabilities, for good.
+func switchToDecoderAccount() error {
+	if err := dropBoundingCapabilities(); err != nil {
+		return err
+	}
+	if err := syscall.Setgroups(nil); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setresgid(sandboxDecoderID, sandboxDecoderID, sandboxDecoderID); err != nil {
+		return fmt.Errorf("setresgid: %w", err)
+	}
+	// Leaving uid 0 clears the permitted, effective and ambient sets
+	if err := syscall.Setresuid(sandboxDecoderID, sandboxDecoderID, sandboxDecoderID); err != nil {
+		return fmt.Errorf("setresuid: %w", err)
+	}
+	if err := clearCapabilities(); err != nil {
+		return err
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("no_new_privs: %w", errno)
+	}
+	// Changing credentials resets the parent-death signal
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetPdeathsig, uintptr(syscall.SIGKILL), 0); errno != 0 {
+		return fmt.Errorf("pdeathsig: %w", errno)
+	}
+	return nil
+}
+
+// dropBoundingCapabilities empties the capability bounding set, so execve can never grant a
+// capability again.
+func dropBoundingCapabilities() error {
+	for c := uintptr(0); c < 64; c++ {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapbsetDrop, c, 0); errno == syscall.EINVAL {
+			break // past the last capability the kernel knows
+		} else if errno != 0 {
+			return fmt.Errorf("drop bounding capability %d: %w", c, errno)
+		}
+	}
+	return nil
+}
+
+// clearCapabilities empties the permitted, effective and inheritable sets.
+func clearCapabilities() error {
+	hdr, data := capHeader{version: linuxCapVersion3}, [2]capData{}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capset: %w", errno)
+	}
+	return nil
+}
+
+// probeSandbox verifies from inside that the sandbox of tier holds and leaves sandboxProbeFile
+// in each writable directory for the backend to check.
+func probeSandbox(tier string, ro, rw []string) error {
+	hdr, data := capHeader{version: linuxCapVersion3}, [2]capData{}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capget: %w", errno)
+	}
+	if data[0] != (capData{}) || data[1] != (capData{}) {
+		return errors.New("capabilities were not dropped")
+	}
+	inputsReadOnly := true
+	if tier == sandboxTierNamespaces {
+		if err := probeNamespaces(); err != nil {
+			return err
+		}
+	} else {
+		if err := probeRestricted(); err != nil {
+			return err
+		}
+		inputsReadOnly = landlockABI() > 0
+	}
+	for _, f := range ro {
+		if _, err := os.ReadFile(f); err != nil {
+			return fmt.Errorf("input not readable: %w", err)
+		}
+		if err := os.WriteFile(f, nil, 0644); err == nil && inputsReadOnly {
+			return fmt.Errorf("input %s is writable", f)
+		}
+	}
+	for _, d := range rw {
+		if err := os.WriteFile(filepath.Join(d, sandboxProbeFile), []byte("ok"), 0644); err != nil {
+			return fmt.Errorf("output directory not writable: %w", err)
+		}
+	}
+	os.Setenv("PATH", sandboxPath)
+	if _, err := exec.LookPath("nvlog_decoder"); err != nil {
+		return fmt.Errorf("nvlog_decoder not found inside the sandbox: %w", err)
+	}
+	return nil
+}
+
+// probeNamespaces checks the namespaces tier: decoder account, PID 1, no network, no host paths.
+func probeNamespaces() error {
+	if os.Getuid() != sandboxDecoderID || os.Getgid() != sandboxDecoderID {
+		return fmt.Errorf("running as uid %d gid %d inside the namespace, not the decoder account", os.Getuid(), os.Getgid())
+	}
+	if os.Getpid() != 1 {
+		return fmt.Errorf("not in a new PID namespace (pid %d)", os.Getpid())
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return fmt.Errorf("list network interfaces: %w", err)
+	}
+	for _, ifc := range ifaces {
+		if ifc.Flags&net.FlagLoopback == 0 || ifc.Flags&net.FlagUp != 0 {
+			return fmt.Errorf("network interface %s is reachable", ifc.Name)
+		}
+	}
+	for _, p := range []string{"/etc/passwd", "/root", "/home"} {
+		if _, err := os.Stat(p); err == nil {
+			return fmt.Errorf("host path %s is visible", p)
+		}
+	}
+	return nil
+}
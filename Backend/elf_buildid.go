@@ -0,0 +1,85 @@
+package main
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- ELF GNU Build ID ----------------
+// ------------------------------------------------------------------------------------------------
+
+// errNoBuildID means the file is a valid ELF without an NT_GNU_BUILD_ID note. Such an ELF can
+// never be matched to a log, so callers reject it instead of storing it under another key.
+var errNoBuildID = errors.New("ELF has no GNU build-id note (link with --build-id)")
+
+// errNotELF wraps failures to parse the file as ELF at all.
+var errNotELF = errors.New("not a valid ELF file")
+
+const ntGNUBuildID = 3 // NT_GNU_BUILD_ID
+
+// extractBuildIDFromELF returns the GNU build ID of an ELF file as lower-case hex. Note
+// sections are searched first; files stripped of section headers fall back to PT_NOTE segments.
+func extractBuildIDFromELF(elfPath string) (string, error) {
+	f, err := elf.Open(elfPath)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errNotELF, err)
+	}
+	defer f.Close()
+	for _, s := range f.Sections {
+		if s.Type != elf.SHT_NOTE {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			return "", fmt.Errorf("%w: read section %s: %v", errNotELF, s.Name, err)
+		}
+		if id := findGNUBuildID(f.ByteOrder, data); id != "" {
+			return id, nil
+		}
+	}
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_NOTE {
+			continue
+		}
+		data, err := io.ReadAll(p.Open())
+		if err != nil {
+			return "", fmt.Errorf("%w: read note segment: %v", errNotELF, err)
+		}
+		if id := findGNUBuildID(f.ByteOrder, data); id != "" {
+			return id, nil
+		}
+	}
+	return "", errNoBuildID
+}
+
+// findGNUBuildID walks the notes in data (namesz, descsz, type, name, desc; name and desc
+// padded to 4 bytes) and returns the descriptor of the first "GNU" NT_GNU_BUILD_ID note.
+func findGNUBuildID(order binary.ByteOrder, data []byte) string {
+	align4 := func(n uint64) uint64 { return (n + 3) &^ 3 }
+	for len(data) >= 12 {
+		namesz := uint64(order.Uint32(data[0:4]))
+		descsz := uint64(order.Uint32(data[4:8]))
+		typ := order.Uint32(data[8:12])
+		data = data[12:]
+		nameEnd := align4(namesz)
+		descEnd := nameEnd + align4(descsz)
+		if nameEnd+descsz > uint64(len(data)) {
+			return ""
+		}
+		name := data[:namesz]
+		desc := data[nameEnd : nameEnd+descsz]
+		if typ == ntGNUBuildID && string(name) == "GNU\x00" && descsz > 0 {
+			return hex.EncodeToString(desc)
+		}
+		if descEnd > uint64(len(data)) {
+			break
+		}
+		data = data[descEnd:]
+	}
+	return ""
+}
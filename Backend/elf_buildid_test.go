@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// note encodes one ELF note: namesz, descsz, type, then name and desc each padded to 4 bytes.
+func note(order binary.ByteOrder, name string, typ uint32, desc []byte) []byte {
+	pad := func(b []byte) []byte { return append(b, make([]byte, (4-len(b)%4)%4)...) }
+	b := make([]byte, 12)
+	order.PutUint32(b[0:], uint32(len(name)))
+	order.PutUint32(b[4:], uint32(len(desc)))
+	order.PutUint32(b[8:], typ)
+	b = append(b, pad([]byte(name))...)
+	return append(b, pad(append([]byte{}, desc...))...)
+}
+
+var (
+	testID    = []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb}
+	testIDHex = "0123456789abcdef00112233445566778899aabb"
+	le        = binary.LittleEndian
+)
+
+func TestFindGNUBuildID(t *testing.T) {
+	buildID := note(le, "GNU\x00", ntGNUBuildID, testID)
+	cat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	truncatedDesc := note(le, "GNU\x00", ntGNUBuildID, testID)
+	le.PutUint32(truncatedDesc[4:], 64)
+	tests := []struct {
+		name  string
+		order binary.ByteOrder
+		data  []byte
+		want  string
+	}{
+		{name: "build-id only", data: buildID, want: testIDHex},
+		{name: "big-endian", order: binary.BigEndian, data: note(binary.BigEndian, "GNU\x00", ntGNUBuildID, testID), want: testIDHex},
+		{name: "non-GNU note first", data: cat(note(le, "Go\x00\x00", ntGNUBuildID, []byte("go-build-id")), buildID), want: testIDHex},
+		{name: "other GNU note first", data: cat(note(le, "GNU\x00", 1, []byte{0, 0, 0, 0, 3, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0}), buildID), want: testIDHex},
+		{name: "padded name and desc before", data: cat(note(le, "Linux\x00", 6, []byte("5.10x")), buildID), want: testIDHex},
+		{name: "unpadded desc at the end", data: note(le, "GNU\x00", ntGNUBuildID, testID[:9]), want: testIDHex[:18]},
+		{name: "descsz past the end", data: truncatedDesc},
+		{name: "descsz past the end stops the walk", data: cat(truncatedDesc, buildID)},
+		{name: "trailing bytes after non-matching note", data: cat(note(le, "Go\x00\x00", 4, []byte("x")), []byte{1, 2, 3})},
+		{name: "empty desc", data: note(le, "GNU\x00", ntGNUBuildID, nil)},
+		{name: "name without NUL", data: note(le, "GNU", ntGNUBuildID, testID)},
+		{name: "no notes", data: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := tt.order
+			if order == nil {
+				order = le
+			}
+			if got := findGNUBuildID(order, tt.data); got != tt.want {
+				t.Errorf("findGNUBuildID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeTestELF writes a minimal little-endian ELF64 file: section holds the contents of a
+// .note section (none if nil) and segment those of a PT_NOTE segment (none if nil).
+func writeTestELF(t *testing.T, section, segment []byte) string {
+	t.Helper()
+	const ehsize, phentsize, shentsize = 64, 56, 64
+	var phnum, shnum uint16
+	if segment != nil {
+		phnum = 1
+	}
+	shstrtab := []byte("\x00.note\x00.shstrtab\x00")
+	segOff := uint64(ehsize + phentsize*int(phnum))
+	secOff := segOff + uint64(len(segment))
+	strOff := secOff + uint64(len(section))
+	shOff := (strOff + uint64(len(shstrtab)) + 7) &^ 7
+	if section != nil {
+		shnum = 3
+	}
+	var ident [elf.EI_NIDENT]byte
+	copy(ident[:], elf.ELFMAG)
+	ident[elf.EI_CLASS], ident[elf.EI_DATA], ident[elf.EI_VERSION] = byte(elf.ELFCLASS64), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT)
+	hdr := elf.Header64{
+		Ident: ident, Type: uint16(elf.ET_EXEC), Machine: uint16(elf.EM_RISCV), Version: uint32(elf.EV_CURRENT),
+		Ehsize: ehsize, Phentsize: phentsize, Phnum: phnum, Shentsize: shentsize, Shnum: shnum,
+	}
+	if phnum > 0 {
+		hdr.Phoff = ehsize
+	}
+	if shnum > 0 {
+		hdr.Shoff, hdr.Shstrndx = shOff, 2
+	}
+	var b bytes.Buffer
+	binary.Write(&b, le, hdr)
+	if phnum > 0 {
+		binary.Write(&b, le, elf.Prog64{Type: uint32(elf.PT_NOTE), Flags: uint32(elf.PF_R), Off: segOff, Filesz: uint64(len(segment)), Memsz: uint64(len(segment)), Align: 4})
+	}
+	b.Write(segment)
+	b.Write(section)
+	if shnum > 0 {
+		b.Write(shstrtab)
+		b.Write(make([]byte, shOff-strOff-uint64(len(shstrtab))))
+		for _, s := range []elf.Section64{
+			{},
+			{Name: 1, Type: uint32(elf.SHT_NOTE), Flags: uint64(elf.SHF_ALLOC), Off: secOff, Size: uint64(len(section)), Addralign: 4},
+			{Name: 7, Type: uint32(elf.SHT_STRTAB), Off: strOff, Size: uint64(len(shstrtab)), Addralign: 1},
+		} {
+			binary.Write(&b, le, s)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "test.elf")
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtractBuildIDFromELF(t *testing.T) {
+	buildID := note(le, "GNU\x00", ntGNUBuildID, testID)
+	other := note(le, "Go\x00\x00", 4, []byte("go-build-id"))
+	notELF := filepath.Join(t.TempDir(), "notelf")
+	if err := os.WriteFile(notELF, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr error
+	}{
+		{name: "note section", path: writeTestELF(t, append(append([]byte{}, other...), buildID...), nil), want: testIDHex},
+		{name: "PT_NOTE fallback without section headers", path: writeTestELF(t, nil, buildID), want: testIDHex},
+		{name: "PT_NOTE fallback after notes without build-id", path: writeTestELF(t, other, buildID), want: testIDHex},
+		{name: "no build-id", path: writeTestELF(t, other, other), wantErr: errNoBuildID},
+		{name: "no notes", path: writeTestELF(t, nil, nil), wantErr: errNoBuildID},
+		{name: "not ELF", path: notELF, wantErr: errNotELF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractBuildIDFromELF(tt.path)
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("extractBuildIDFromELF() = %q, %v; want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- Stored ELF selection ----------------
+// ------------------------------------------------------------------------------------------------
+
+// By default a decode uses the stored ELF whose Build ID is in the log header. The form fields
+// below override that choice; like git with short hashes, buildId may be any unambiguous prefix
+// of at least minBuildIDPrefix hex digits.
+//
+//	elfFileName   stored filename, e.g. display-t234-dce-log.elf__<pushtag>__<buildId>
+//	pushtag       an ELF fetched for this pushtag ("auto" or empty = any)
+//	buildId       full Build ID or prefix; overrides the Build ID of the log header
+//	elfMismatch   warn (default) | fail when the ELF's Build ID differs from the log's
+
+const (
+	minBuildIDPrefix = 4
+	// maxELFCandidates bounds the candidates listed for an ambiguous selection
+	maxELFCandidates = 20
+)
+
+var buildIDPattern = regexp.MustCompile(`^[0-9a-f]{4,64}$`)
+
+// codeELFAmbiguous reports a selection that matches several stored ELFs.
+const codeELFAmbiguous = "elf_ambiguous"
+
+// elfSelection is the ELF part of a decode request; build it with parseELFSelection.
+type elfSelection struct {
+	BuildID      string // lower-case hex, full or prefix; "" = from the log header
+	ElfFileName  string
+	Pushtag      string // "auto" when not pinned
+	FailMismatch bool
+}
+
+// parseELFSelection reads the selection fields through get (e.g. r.FormValue) and validates them.
+func parseELFSelection(get func(string) string) (elfSelection, error) {
+	sel := elfSelection{
+		BuildID:     strings.ToLower(strings.TrimSpace(get("buildId"))),
+		ElfFileName: strings.TrimSpace(get("elfFileName")),
+		Pushtag:     strings.TrimSpace(get("pushtag")),
+	}
+	if sel.Pushtag == "" {
+		sel.Pushtag = "auto"
+	}
+	if sel.BuildID != "" && !buildIDPattern.MatchString(sel.BuildID) {
+		return sel, fmt.Errorf("invalid buildId %q (expected %d to 64 hex digits)", sel.BuildID, minBuildIDPrefix)
+	}
+	if strings.ContainsAny(sel.ElfFileName, "/\\") {
+		return sel, fmt.Errorf("invalid elfFileName %q", sel.ElfFileName)
+	}
+	switch mode := strings.ToLower(get("elfMismatch")); mode {
+	case "", "warn":
+	case "fail":
+		sel.FailMismatch = true
+	default:
+		return sel, fmt.Errorf("invalid elfMismatch %q (expected warn or fail)", mode)
+	}
+	return sel, nil
+}
+
+// pinsPushtag reports whether the selection restricts the ELF to one pushtag.
+func (s elfSelection) pinsPushtag() bool {
+	return s.Pushtag != "" && s.Pushtag != "auto"
+}
+
+// picksELF reports whether the selection names an ELF without the log's Build ID.
+func (s elfSelection) picksELF() bool {
+	return s.BuildID != "" || s.ElfFileName != "" || s.pinsPushtag()
+}
+
+// elfAmbiguousError is a decodeError (409 elf_ambiguous) carrying the matching ELFs.
+type elfAmbiguousError struct {
+	*decodeError
+	Candidates []ELFCandidate
+}
+
+func (e *elfAmbiguousError) Unwrap() error { return e.decodeError }
+
+// elfCandidates returns the candidates of an ambiguous selection (nil for other errors).
+func elfCandidates(err error) []ELFCandidate {
+	var e *elfAmbiguousError
+	if errors.As(err, &e) {
+		return e.Candidates
+	}
+	return nil
+}
+
+func newELFAmbiguousError(what string, records []ElfRecord) error {
+	more := ""
+	if len(records) > maxELFCandidates {
+		records, more = records[:maxELFCandidates], ", ..."
+	}
+	candidates := make([]ELFCandidate, len(records))
+	names := make([]string, len(records))
+	for i, rec := range records {
+		candidates[i] = ELFCandidate{BuildID: rec.BuildID, ElfFileName: rec.ElfFileName, Pushtag: elfPushtag(rec.ElfFileName)}
+		names[i] = rec.BuildID + " (" + rec.ElfFileName + ")"
+	}
+	return &elfAmbiguousError{
+		decodeError: &decodeError{http.StatusConflict, codeELFAmbiguous, fmt.Sprintf("%s matches several ELFs; narrow it down with buildId or elfFileName. Candidates: %s%s", what, strings.Join(names, ", "), more)},
+		Candidates:  candidates,
+	}
+}
+
+// resolveStoredELF picks the build_elves row for sel; buildID is the Build ID to look up when
+// the selection names none (sel.BuildID, else the log's). Errors are *decodeError.
+func resolveStoredELF(sel elfSelection, buildID string) (ElfRecord, error) {
+	buildID = strings.ToLower(buildID)
+	dbError := func(err error) (ElfRecord, error) {
+		log.Printf("DB error while selecting ELF (buildId=%q, elfFileName=%q, pushtag=%q): %v", buildID, sel.ElfFileName, sel.Pushtag, err)
+		return ElfRecord{}, &decodeError{http.StatusInternalServerError, codeInternal, "Internal server error: database failure while fetching ELF."}
+	}
+	switch {
+	case sel.ElfFileName != "":
+		records, err := findELFsByFileName(sel.ElfFileName, maxELFCandidates+1)
+		if err != nil {
+			return dbError(err)
+		}
+		switch len(records) {
+		case 0:
+			return ElfRecord{}, &decodeError{http.StatusNotFound, codeELFMissing, fmt.Sprintf("No ELF stored as %q.", sel.ElfFileName)}
+		case 1:
+			return records[0], nil
+		}
+		return ElfRecord{}, newELFAmbiguousError("elfFileName "+sel.ElfFileName, records)
+
+	case sel.pinsPushtag():
+		records, err := findELFsByPushtag(sel.Pushtag)
+		if err != nil {
+			return dbError(err)
+		}
+		if len(records) == 0 {
+			return ElfRecord{}, &decodeError{http.StatusNotFound, codeELFMissing, fmt.Sprintf("No ELF stored for pushtag %s. Please fetch it in Admin.", sel.Pushtag)}
+		}
+		// A pushtag may hold several builds; the Build ID narrows it down if one matches
+		if buildID != "" {
+			var matching []ElfRecord
+			for _, rec := range records {
+				if strings.HasPrefix(strings.ToLower(rec.BuildID), buildID) {
+					matching = append(matching, rec)
+				}
+			}
+			if len(matching) > 0 {
+				records = matching
+			}
+		}
+		if len(records) > 1 {
+			return ElfRecord{}, newELFAmbiguousError("Pushtag "+sel.Pushtag, records)
+		}
+		return records[0], nil
+
+	default:
+		name, err := getELFNameByBuildID(buildID)
+		if err == nil {
+			return ElfRecord{BuildID: buildID, ElfFileName: name}, nil
+		}
+		if err != sql.ErrNoRows {
+			return dbError(err)
+		}
+		records, err := findELFsByBuildIDPrefix(buildID, maxELFCandidates+1)
+		if err != nil {
+			return dbError(err)
+		}
+		switch len(records) {
+		case 0:
+			return ElfRecord{}, &decodeError{http.StatusNotFound, codeELFMissing, fmt.Sprintf("ELF not found for buildId %s. Please upload/fetch the matching ELF in Admin.", buildID)}
+		case 1:
+			return records[0], nil
+		}
+		return ElfRecord{}, newELFAmbiguousError("Build ID prefix "+buildID, records)
+	}
+}
+
+// elfMismatch describes why the ELF with elfBuildID may not fit a log expecting buildID
+// ("" if it fits). buildID may be a prefix.
+func elfMismatch(elfBuildID, buildID string) string {
+	switch {
+	case elfBuildID == "":
+		return "ELF has no GNU build-id note; cannot verify it against the log"
+	case buildID == "":
+		return "log carries no Build ID; cannot verify the ELF against it"
+	case !strings.HasPrefix(strings.ToLower(elfBuildID), strings.ToLower(buildID)):
+		return fmt.Sprintf("ELF has Build ID %s, the log expects %s", elfBuildID, buildID)
+	}
+	return ""
+}
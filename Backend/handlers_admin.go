@@ -1,10 +1,12 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
 	"os/exec"
@@ -26,17 +28,30 @@ func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
 			writeJSONError(w, "Invalid request format for adding user.", http.StatusBadRequest)
 			return
 		}
-		if newUserPayload.Username == "" || newUserPayload.Password == "" || (newUserPayload.Role != "admin" && newUserPayload.Role != "user") {
+		setAuditTarget(r, "username="+newUserPayload.Username)
+		if newUserPayload.Username == "" || newUserPayload.Password == "" {
 			writeJSONError(w, "Username and password cannot be empty.", http.StatusBadRequest)
 			return
 		}
+		if ok, err := roleExists(newUserPayload.Role); err != nil {
+			writeJSONError(w, "Failed to validate role.", http.StatusInternalServerError)
+			return
+		} else if !ok {
+			writeJSONError(w, fmt.Sprintf("Unknown role '%s'.", newUserPayload.Role), http.StatusBadRequest)
+			return
+		}
 		newID, err := randomIDHex(12)
 		if err != nil {
 			writeJSONError(w, "Internal server error: id generation.", http.StatusInternalServerError)
 			return
 		}
+		passwordHash, err := hashPassword(newUserPayload.Password)
+		if err != nil {
+			writeJSONError(w, "Internal server error: password hashing.", http.StatusInternalServerError)
+			return
+		}
 		_, err = db.Exec("INSERT INTO users (id, username, password, role) VALUES (?, ?, ?, ?)",
-			newID, newUserPayload.Username, newUserPayload.Password, newUserPayload.Role)
+			newID, newUserPayload.Username, passwordHash, newUserPayload.Role)
 		if err != nil {
 			writeJSONError(w, fmt.Sprintf("Failed to add user: %v", err), http.StatusConflict)
 			return
@@ -49,7 +64,7 @@ func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	} else if r.Method == http.MethodGet {
 		rows, err := db.Query(`
-			SELECT id, username, password, role
+			SELECT id, username, password, role, auth_source, disabled, must_change_password
 			FROM users
 			ORDER BY CASE WHEN role='admin' THEN 0 ELSE 1 END, username ASC`)
 		if err != nil {
@@ -60,7 +75,7 @@ func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
 		usersList := make([]User, 0, 32)
 		for rows.Next() {
 			var u User
-			if err := rows.Scan(&u.ID, &u.Username, &u.Password, &u.Role); err != nil {
+			if err := rows.Scan(&u.ID, &u.Username, &u.Password, &u.Role, &u.AuthSource, &u.Disabled, &u.MustChangePassword); err != nil {
 				writeJSONError(w, "Failed to read users.", http.StatusInternalServerError)
 				return
 			}
@@ -80,20 +95,191 @@ func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
 			writeJSONError(w, "Missing user id.", http.StatusBadRequest)
 			return
 		}
-		res, err := db.Exec("DELETE FROM users WHERE id = ?", id)
+		tx, err := db.Begin()
 		if err != nil {
 			writeJSONError(w, "Failed to delete user.", http.StatusInternalServerError)
 			return
 		}
-		aff, _ := res.RowsAffected()
-		if aff == 0 {
+		defer tx.Rollback()
+		var username, role string
+		var disabled bool
+		if err := tx.QueryRow("SELECT username, role, disabled FROM users WHERE id = ? FOR UPDATE", id).Scan(&username, &role, &disabled); err != nil {
 			writeJSONError(w, "User not found.", http.StatusNotFound)
 			return
 		}
+		if username == principalFromRequest(r).Username {
+			writeJSONError(w, "You cannot delete your own account.", http.StatusBadRequest)
+			return
+		}
+		if role == superuserRole && !disabled {
+			if last, err := isLastActiveAdmin(tx); err != nil {
+				writeJSONError(w, "Failed to delete user.", http.StatusInternalServerError)
+				return
+			} else if last {
+				writeJSONError(w, "Cannot delete the last remaining admin.", http.StatusConflict)
+				return
+			}
+		}
+		if _, err := tx.Exec("DELETE FROM users WHERE id = ?", id); err != nil {
+			writeJSONError(w, "Failed to delete user.", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			writeJSONError(w, "Failed to delete user.", http.StatusInternalServerError)
+			return
+		}
+		if _, err := db.Exec("DELETE FROM api_tokens WHERE user_id = ?", id); err != nil {
+			log.Printf("Failed to delete API tokens of user %s: %v", id, err)
+		}
+		if _, err := db.Exec("DELETE FROM refresh_tokens WHERE user_id = ?", id); err != nil {
+			log.Printf("Failed to delete refresh tokens of user %s: %v", id, err)
+		}
 		json.NewEncoder(w).Encode(ResponsePayload{Message: "User deleted.", Success: true})
 		return
+	} else if r.Method == http.MethodPatch || r.Method == http.MethodPut {
+		updateAdminUser(w, r)
+		return
+	}
+	writeJSONError(w, "Only GET, POST, PATCH/PUT and DELETE requests are supported.", http.StatusMethodNotAllowed)
+}
+
+// isLastActiveAdmin reports whether at most one enabled admin is left. The admin rows are
+// locked so two concurrent demotions cannot both pass the check.
+func isLastActiveAdmin(tx *sql.Tx) (bool, error) {
+	rows, err := tx.Query("SELECT id FROM users WHERE role = ? AND disabled = FALSE FOR UPDATE", superuserRole)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		n++
 	}
-	writeJSONError(w, "Only GET, POST and DELETE requests are supported.", http.StatusMethodNotAllowed)
+	return n <= 1, rows.Err()
+}
+
+// updateAdminUser edits a user in place (PATCH/PUT JSON UpdateAdminUser). Renames, password
+// changes and disabling revoke the user's sessions; role changes invalidate access tokens
+// through the role check in checkTokenRevocation.
+func updateAdminUser(w http.ResponseWriter, r *http.Request) {
+	var req UpdateAdminUser
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		req.ID = r.URL.Query().Get("id")
+	}
+	setAuditTarget(r, "id="+req.ID)
+	if req.ID == "" {
+		writeJSONError(w, "Missing user id.", http.StatusBadRequest)
+		return
+	}
+	if req.Username == nil && req.Role == nil && req.Password == nil && req.Disabled == nil {
+		writeJSONError(w, "Nothing to update.", http.StatusBadRequest)
+		return
+	}
+	if req.Role != nil {
+		if ok, err := roleExists(*req.Role); err != nil {
+			writeJSONError(w, "Failed to validate role.", http.StatusInternalServerError)
+			return
+		} else if !ok {
+			writeJSONError(w, fmt.Sprintf("Unknown role '%s'.", *req.Role), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Username != nil {
+		trimmed := strings.TrimSpace(*req.Username)
+		if trimmed == "" {
+			writeJSONError(w, "Username cannot be empty.", http.StatusBadRequest)
+			return
+		}
+		req.Username = &trimmed
+	}
+	if req.Password != nil && *req.Password == "" {
+		writeJSONError(w, "Password cannot be empty.", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		writeJSONError(w, "Failed to update user.", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	var cur User
+	err = tx.QueryRow("SELECT username, role, auth_source, disabled FROM users WHERE id = ? FOR UPDATE", req.ID).
+		Scan(&cur.Username, &cur.Role, &cur.AuthSource, &cur.Disabled)
+	if err == sql.ErrNoRows {
+		writeJSONError(w, "User not found.", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeJSONError(w, "Failed to update user.", http.StatusInternalServerError)
+		return
+	}
+	if cur.AuthSource != "local" && (req.Username != nil || req.Password != nil) {
+		writeJSONError(w, fmt.Sprintf("Username and password of %s users are managed externally.", cur.AuthSource), http.StatusBadRequest)
+		return
+	}
+	demoting := req.Role != nil && *req.Role != superuserRole
+	disabling := req.Disabled != nil && *req.Disabled
+	if cur.Role == superuserRole && !cur.Disabled && (demoting || disabling) {
+		if last, err := isLastActiveAdmin(tx); err != nil {
+			writeJSONError(w, "Failed to update user.", http.StatusInternalServerError)
+			return
+		} else if last {
+			writeJSONError(w, "Cannot demote or disable the last remaining admin.", http.StatusConflict)
+			return
+		}
+	}
+
+	sets := []string{}
+	args := []interface{}{}
+	revoke := false
+	if req.Username != nil && *req.Username != cur.Username {
+		sets = append(sets, "username = ?")
+		args = append(args, *req.Username)
+		revoke = true
+	}
+	if req.Role != nil {
+		sets = append(sets, "role = ?")
+		args = append(args, *req.Role)
+	}
+	if req.Password != nil {
+		hash, err := hashPassword(*req.Password)
+		if err != nil {
+			writeJSONError(w, "Internal server error: password hashing.", http.StatusInternalServerError)
+			return
+		}
+		sets = append(sets, "password = ?", "must_change_password = TRUE")
+		args = append(args, hash)
+		revoke = true
+	}
+	if req.Disabled != nil {
+		sets = append(sets, "disabled = ?")
+		args = append(args, *req.Disabled)
+		revoke = revoke || *req.Disabled
+	}
+	if revoke {
+		sets = append(sets, "token_version = token_version + 1")
+	}
+	args = append(args, req.ID)
+	if _, err := tx.Exec("UPDATE users SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...); err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to update user: %v", err), http.StatusConflict)
+		return
+	}
+	if revoke {
+		if _, err := tx.Exec("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL", req.ID); err != nil {
+			writeJSONError(w, "Failed to update user.", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSONError(w, "Failed to update user.", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User '%s' updated by %s.", cur.Username, principalFromRequest(r).Username)
+	json.NewEncoder(w).Encode(ResponsePayload{Message: fmt.Sprintf("User '%s' updated.", cur.Username), Success: true})
 }
 
 // POST JSON {pushtag, url} -> fetch archives, extract ELF, read BuildID, store to DB
@@ -108,6 +294,7 @@ func adminElvesByURLHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, "Invalid request body.", http.StatusBadRequest)
 		return
 	}
+	setAuditTarget(r, "pushtag="+req.Pushtag)
 	if req.Pushtag == "" || req.URL == "" {
 		writeJSONError(w, "Pushtag and URL cannot be empty.", http.StatusBadRequest)
 		return
@@ -165,8 +352,8 @@ func adminElvesByURLHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	buildID, err := extractBuildIDFromELF(elfPath)
-	if err != nil || buildID == "" {
-		writeJSONError(w, "Failed to extract Build ID from ELF.", http.StatusInternalServerError)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Failed to extract Build ID from ELF: %v", err), http.StatusUnprocessableEntity)
 		return
 	}
 	elfBytes, err := os.ReadFile(elfPath)
@@ -244,7 +431,7 @@ func performByURLJob(job *ByURLJob) {
 
 	emitStep("Extracting Build ID from ELF...")
 	buildID, err := extractBuildIDFromELF(elfPath)
-	if err != nil || buildID == "" { emitErr(fmt.Errorf("extract build id failed")); return }
+	if err != nil { emitErr(fmt.Errorf("extract build id: %w", err)); return }
 
 	emitStep("Reading ELF bytes...")
 	elfBytes, err := os.ReadFile(elfPath)
@@ -322,6 +509,7 @@ func adminElvesByURLStartHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, "Invalid request body.", http.StatusBadRequest)
 		return
 	}
+	setAuditTarget(r, "pushtag="+req.Pushtag)
 	if req.Pushtag == "" || req.URL == "" {
 		writeJSONError(w, "Pushtag and URL cannot be empty.", http.StatusBadRequest)
 		return
@@ -401,6 +589,8 @@ func adminElvesByURLStreamHandler(w http.ResponseWriter, r *http.Request) {
 		if created {
 			go performByURLJob(job)
 		}
+		// a GET, so withAudit does not see it; audited like POST /start
+		writeAuditEvent(principalFromRequest(r).Username, "elves.fetch-by-url.start", "pushtag="+pushtag, clientIP(r), auditResult(http.StatusOK), http.StatusOK)
 	}
 
 	// subscribe and stream events
@@ -474,11 +664,13 @@ func adminElvesUploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	out.Close()
 
+	setAuditTarget(r, "file="+header.Filename)
 	buildID, err := extractBuildIDFromELF(tmpElf)
-	if err != nil || buildID == "" {
-		writeJSONError(w, "Failed to extract Build ID from uploaded ELF.", http.StatusInternalServerError)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Rejected uploaded ELF: %v", err), http.StatusBadRequest)
 		return
 	}
+	setAuditTarget(r, "buildId="+buildID)
 	elfBytes, err := os.ReadFile(tmpElf)
 	if err != nil {
 		writeJSONError(w, "Failed to read uploaded ELF.", http.StatusInternalServerError)
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- Batch decode ----------------
+// ------------------------------------------------------------------------------------------------
+
+// POST /api/decode/batch decodes many logs in one request. Every `file` part is either a log
+// or a zip / tar / tar.gz archive of logs. Each log gets its own header parse, Build ID and ELF
+// lookup; a log that fails is recorded in the manifest and never fails the batch. The response
+// is a zip with decoded/<name> per decoded log and manifest.json (BatchManifest).
+var (
+	batchMaxFiles = parseIntEnv("DECODE_BATCH_MAX_FILES", 200)
+	batchMaxBytes = int64(parseIntEnv("DECODE_BATCH_MAX_MB", 1024)) << 20 // all logs, after extraction
+)
+
+var errBatchTooLarge = errors.New("batch too large")
+
+// batchInput is one log of a batch, saved as <dir>/<n>/dce-enc.log.
+type batchInput struct {
+	Name string
+	Path string
+}
+
+// batchCollector saves uploaded logs and archive members while enforcing the batch limits.
+type batchCollector struct {
+	dir    string
+	inputs []batchInput
+	// outputs holds the batchOutputName of every name so far, in lower case for archivers
+	// on case-insensitive filesystems
+	outputs map[string]bool
+	bytes   int64
+}
+
+// add saves one log. Names only label the log in the manifest; files on disk are numbered.
+func (c *batchCollector) add(name string, src io.Reader) error {
+	if len(c.inputs) >= batchMaxFiles {
+		return fmt.Errorf("%w: more than %d logs", errBatchTooLarge, batchMaxFiles)
+	}
+	// Several parts named dce-enc.log are common; number the duplicates. Different names can
+	// share an output (a.log and a, or x-2.log and the second x.log), so that is what must differ.
+	unique := name
+	for n := 2; c.outputs[strings.ToLower(batchOutputName(unique))]; n++ {
+		ext := path.Ext(name)
+		unique = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), n, ext)
+	}
+	name = unique
+	c.outputs[strings.ToLower(batchOutputName(name))] = true
+	dir := filepath.Join(c.dir, strconv.Itoa(len(c.inputs)))
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return err
+	}
+	p := filepath.Join(dir, "dce-enc.log")
+	n, err := func() (int64, error) {
+		out, err := os.Create(p)
+		if err != nil {
+			return 0, err
+		}
+		defer out.Close()
+		return io.Copy(out, io.LimitReader(src, batchMaxBytes-c.bytes+1))
+	}()
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	c.bytes += n
+	if c.bytes > batchMaxBytes {
+		return fmt.Errorf("%w: logs exceed %d MB", errBatchTooLarge, batchMaxBytes>>20)
+	}
+	c.inputs = append(c.inputs, batchInput{Name: name, Path: p})
+	return nil
+}
+
+// addPart adds an uploaded part, expanding it if it is an archive.
+func (c *batchCollector) addPart(fh *multipart.FileHeader) error {
+	f, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := f.ReadAt(head, 0)
+	head = head[:n]
+	switch {
+	case strings.HasPrefix(string(head), "PK\x03\x04"), strings.HasPrefix(string(head), "PK\x05\x06"):
+		zr, err := zip.NewReader(f, fh.Size)
+		if err != nil {
+			return fmt.Errorf("%s: invalid zip archive: %v", fh.Filename, err)
+		}
+		for _, zf := range zr.File {
+			if !zf.Mode().IsRegular() || skipArchiveMember(zf.Name) {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return fmt.Errorf("%s: %s: %v", fh.Filename, zf.Name, err)
+			}
+			err = c.add(archiveMemberName(zf.Name), rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case strings.HasPrefix(string(head), "\x1f\x8b"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: invalid gzip archive: %v", fh.Filename, err)
+		}
+		defer gz.Close()
+		return c.addTar(fh.Filename, gz)
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return c.addTar(fh.Filename, f)
+	}
+	return c.add(path.Base(fh.Filename), f)
+}
+
+func (c *batchCollector) addTar(archiveName string, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: invalid tar archive: %v", archiveName, err)
+		}
+		if hdr.Typeflag != tar.TypeReg || skipArchiveMember(hdr.Name) {
+			continue
+		}
+		if err := c.add(archiveMemberName(hdr.Name), tr); err != nil {
+			return err
+		}
+	}
+}
+
+// archiveMemberName turns a member path into a relative name without ".." components.
+func archiveMemberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, `\`, "/")), "/")
+}
+
+// skipArchiveMember ignores metadata that archivers add next to the real files.
+func skipArchiveMember(name string) bool {
+	base := path.Base(name)
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, "._") || base == ".DS_Store"
+}
+
+// batchStatus classifies a runDecode error for the manifest; ErrorCode has the details. Only
+// failures of nvlog_decoder itself are decoder errors; ELF selection and loading problems,
+// cancellation and internal errors are "error".
+func batchStatus(err error) string {
+	switch code := decodeErrorCode(err); {
+	case code == codeELFMissing:
+		return "elf_missing"
+	case code == codeInvalidLog || code == codeNoBuildID:
+		return "invalid_log"
+	case code != codeDecodeCancelled && strings.HasPrefix(code, "decoder_"):
+		return "decoder_error"
+	}
+	return "error"
+}
+
+// batchBusyPoll is how often a batch checks for room in a full decoder queue.
+const batchBusyPoll = 500 * time.Millisecond
+
+// decodeBatchFile runs one log of a batch. A full queue is not the log's fault: the batch waits
+// for room and retries, so a busy pool slows it down instead of failing its logs.
+func decodeBatchFile(ctx context.Context, req decodeRequest) (*decodeResult, error) {
+	for {
+		res, err := runDecode(ctx, req)
+		if decodeErrorCode(err) != codeDecoderBusy {
+			return res, err
+		}
+		t := time.NewTicker(batchBusyPoll)
+		for decoders.full() {
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return res, &decodeError{http.StatusServiceUnavailable, codeDecodeCancelled, "Decode cancelled while waiting for a decoder."}
+			case <-t.C:
+			}
+		}
+		t.Stop()
+	}
+}
+
+// batchOutputName is the path of a decoded log inside the response archive.
+func batchOutputName(name string) string {
+	return "decoded/" + strings.TrimSuffix(name, ".log") + ".decoded.log"
+}
+
+// decodeBatchHandler decodes every log of the upload and streams back a zip of the results.
+// POST multipart with one or more `file` parts (logs or archives) and optional decode options.
+func decodeBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "Only POST requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+	if decoders.full() {
+		setRetryAfter(w)
+		writeJSONError(w, "All decoders are busy and the queue is full; please retry later.", http.StatusTooManyRequests)
+		return
+	}
+	if err := r.ParseMultipartForm(100 << 20); err != nil {
+		writeJSONError(w, "Invalid multipart form data.", http.StatusBadRequest)
+		return
+	}
+	parts := r.MultipartForm.File["file"]
+	if len(parts) == 0 {
+		writeJSONError(w, "Missing uploaded file field 'file'.", http.StatusBadRequest)
+		return
+	}
+	setAuditTarget(r, fmt.Sprintf("files=%d", len(parts)))
+	// The decode options apply to every log of the batch
+	opts, err := parseDecodeOptions(r.FormValue)
+	if err != nil {
+		writeJSONError(w, "Invalid decode options: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	workDir, err := os.MkdirTemp("", "dce-decode-batch-")
+	if err != nil {
+		log.Printf("Error creating temp dir: %v", err)
+		writeJSONError(w, "Internal server error: Cannot create temp directory.", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(workDir)
+
+	c := &batchCollector{dir: workDir, outputs: map[string]bool{}}
+	for _, fh := range parts {
+		if err := c.addPart(fh); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errBatchTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			writeJSONError(w, fmt.Sprintf("Invalid batch upload: %v", err), status)
+			return
+		}
+	}
+	if len(c.inputs) == 0 {
+		writeJSONError(w, "The upload contains no log files.", http.StatusBadRequest)
+		return
+	}
+
+	// Results are streamed as each log is decoded; the manifest goes last
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"dce-decoded-batch.zip\"")
+	zw := zip.NewWriter(w)
+	manifest := BatchManifest{Total: len(c.inputs), Files: make([]BatchFileResult, 0, len(c.inputs))}
+	for _, in := range c.inputs {
+		if r.Context().Err() != nil {
+			log.Printf("Batch decode aborted by client after %d of %d logs", len(manifest.Files), manifest.Total)
+			return
+		}
+		res, err := decodeBatchFile(r.Context(), decodeRequest{
+			WorkDir: filepath.Dir(in.Path),
+			LogPath: in.Path,
+			Select:  elfSelection{Pushtag: "auto"},
+			Options: opts,
+			Owner:   principalFromRequest(r).Username,
+		})
+		fr := BatchFileResult{Name: in.Name, BuildID: res.BuildID, ElfFileName: res.ElfFileName}
+		if err != nil {
+			fr.Status = batchStatus(err)
+			fr.ErrorCode = decodeErrorCode(err)
+			fr.Error = err.Error()
+			manifest.Failed++
+		} else {
+			fr.Status = "decoded"
+			fr.Output = batchOutputName(in.Name)
+			if err := copyFileToZip(zw, fr.Output, res.OutputPath); err != nil {
+				log.Printf("Batch decode: writing %s failed: %v", fr.Output, err)
+				return
+			}
+			manifest.Decoded++
+		}
+		manifest.Files = append(manifest.Files, fr)
+		// Drop the ELF copy and output right away; a batch can be large
+		os.RemoveAll(filepath.Dir(in.Path))
+	}
+	mf, err := zw.Create("manifest.json")
+	if err == nil {
+		enc := json.NewEncoder(mf)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(manifest)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		log.Printf("Batch decode: writing manifest failed: %v", err)
+		return
+	}
+	log.Printf("Batch decode by %s: %d logs, %d decoded, %d failed", principalFromRequest(r).Username, manifest.Total, manifest.Decoded, manifest.Failed)
+}
+
+func copyFileToZip(zw *zip.Writer, name, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	return err
+}
@@ -1,191 +1,429 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"crypto/sha1"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"decode-dce-log-service/backend/nvlog"
 )
 
 // ------------------------------------------------------------------------------------------------	
 // ---------------- Handler Functions ----------------
 // ------------------------------------------------------------------------------------------------
 
-// extractBuildIDFromELF tries to read GNU Build ID from ELF notes via `readelf -n`.
-// Fallback: use SHA1 of file bytes if GNU Build ID is not present or tool missing.
-func extractBuildIDFromELF(elfPath string) (string, error) {
-	out, err := exec.Command("readelf", "-n", elfPath).CombinedOutput()
-	if err == nil {
-		text := string(out)
-		const key = "Build ID:"
-		if idx := strings.Index(text, key); idx >= 0 {
-			rest := text[idx+len(key):]
-			lineEnd := strings.IndexAny(rest, "\r\n")
-			if lineEnd >= 0 {
-				rest = rest[:lineEnd]
-			}
-			buildID := strings.TrimSpace(rest)
-			if buildID != "" {
-				return buildID, nil
-			}
-		}
-	}
-	// Fallback: SHA1 of file bytes
-	f, err := os.Open(elfPath)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-	h := sha1.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", err
+// decodeHeaderErrorStatus maps an nvlog header parse error to the HTTP status returned to the client.
+func decodeHeaderErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, nvlog.ErrBadMagic):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, nvlog.ErrTruncated), errors.Is(err, nvlog.ErrMalformed), errors.Is(err, nvlog.ErrUnsupportedVersion):
+		return http.StatusUnprocessableEntity
 	}
-	return hex.EncodeToString(h.Sum(nil)), nil
+	return http.StatusInternalServerError
 }
 
-// extractBuildIDFromEncodedLog runs: hexdump -C <log> | head -n 4
-// Then collects bytes at offsets 0x20..0x33 (total 20 bytes) as lower-case 40-hex BuildID.
-func extractBuildIDFromEncodedLog(encodedLogPath string) (string, error) {
-	// Use sh -c to support the pipeline (alpine base image doesn't include bash)
-	cmd := exec.Command("sh", "-c", fmt.Sprintf("hexdump -C %q | head -n 4", encodedLogPath))
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("hexdump failed: %v, output: %s", err, string(out))
-	}
-	text := strings.ToLower(string(out))
-	// Capture 16 bytes from 0x20 line and first 4 bytes from 0x30 line
-	re20 := regexp.MustCompile(`(?m)^00000020\s+([0-9a-f]{2}(?:\s+[0-9a-f]{2}){15})`)
-	re30 := regexp.MustCompile(`(?m)^00000030\s+([0-9a-f]{2}(?:\s+[0-9a-f]{2}){3})`)
-	m20 := re20.FindStringSubmatch(text)
-	m30 := re30.FindStringSubmatch(text)
-	if m20 == nil || m30 == nil {
-		return "", fmt.Errorf("build id bytes not found in hexdump output")
-	}
-	hex20 := strings.ReplaceAll(m20[1], " ", "")
-	hex30 := strings.ReplaceAll(m30[1], " ", "")
-	buildID := hex20 + hex30
-	if len(buildID) != 40 {
-		return "", fmt.Errorf("unexpected build id length: %d", len(buildID))
-	}
-	return buildID, nil
-}
-
-// decodeHandlerMultipart handles multipart upload of dce-enc.log and returns dce-decoded.log as attachment
+// decodeHandlerMultipart handles multipart upload of dce-enc.log and returns dce-decoded.log as
+// attachment, a DecodeResponse for output=json (decode_response.go), or the parsed records for
+// output=ndjson|csv (decoded_records.go)
 func decodeHandlerMultipart(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	asJSON := wantsJSON(r.Header.Get("Accept"))
+	// fail reports an error found before the decode starts; code may be empty
+	fail := func(status int, code, msg string) {
+		if code != "" {
+			w.Header().Set("X-Decode-Error", code)
+		}
+		if !asJSON {
+			http.Error(w, msg, status)
+			return
+		}
+		writeDecodeResponse(w, status, DecodeResponse{
+			ResponsePayload: ResponsePayload{Message: msg},
+			ErrorCode:       code,
+			Timings:         DecodeTimings{TotalMs: sinceMs(started)},
+		})
+	}
+	// Refuse before reading a large upload if it could not be queued anyway
+	if decoders.full() {
+		setRetryAfter(w)
+		fail(http.StatusTooManyRequests, "", "All decoders are busy and the queue is full; please retry later.")
+		return
+	}
 	// Parse multipart with a reasonable max memory (files will be stored in temp files if larger)
 	if err := r.ParseMultipartForm(100 << 20); err != nil { // 100MB
-		http.Error(w, "Invalid multipart form data.", http.StatusBadRequest)
+		fail(http.StatusBadRequest, "", "Invalid multipart form data.")
+		return
+	}
+
+	output, err := parseOutputFormat(r.FormValue("output"), r.Header.Get("Accept"))
+	if err != nil {
+		fail(http.StatusBadRequest, codeInvalidOptions, "Invalid decode options: "+err.Error())
 		return
 	}
+	asJSON = output == outputJSON
 
 	file, header, err := r.FormFile("file")
 	if err != nil {
-		http.Error(w, "Missing uploaded file field 'file'.", http.StatusBadRequest)
+		fail(http.StatusBadRequest, "", "Missing uploaded file field 'file'.")
 		return
 	}
 	defer file.Close()
+	setAuditTarget(r, "file="+header.Filename)
+	opts, err := parseDecodeOptions(r.FormValue)
+	if err != nil {
+		fail(http.StatusBadRequest, codeInvalidOptions, "Invalid decode options: "+err.Error())
+		return
+	}
+	sel, err := parseELFSelection(r.FormValue)
+	if err != nil {
+		fail(http.StatusBadRequest, codeInvalidOptions, "Invalid ELF selection: "+err.Error())
+		return
+	}
 
 	// Create temporary working directory
 	workDir, err := ioutil.TempDir("", "dce-decode-")
 	if err != nil {
 		log.Printf("Error creating temp dir: %v", err)
-		http.Error(w, "Internal server error: Cannot create temp directory.", http.StatusInternalServerError)
+		fail(http.StatusInternalServerError, "", "Internal server error: Cannot create temp directory.")
 		return
 	}
-	defer os.RemoveAll(workDir)
+	// A JSON response may hand the work directory over to a decode job (see keepResult)
+	keptWorkDir := false
+	defer func() {
+		if !keptWorkDir {
+			os.RemoveAll(workDir)
+		}
+	}()
 
 	// Save uploaded file to workDir as dce-enc.log
 	encodedLogPath := filepath.Join(workDir, "dce-enc.log")
-	out, err := os.Create(encodedLogPath)
-	if err != nil {
-		http.Error(w, "Internal server error: Cannot create temp file.", http.StatusInternalServerError)
+	if err := saveUpload(file, encodedLogPath); err != nil {
+		fail(http.StatusInternalServerError, "", "Internal server error: Cannot write uploaded file.")
 		return
 	}
-	if _, err := io.Copy(out, file); err != nil {
-		out.Close()
-		http.Error(w, "Internal server error: Cannot write uploaded file.", http.StatusInternalServerError)
+	log.Printf("Received upload file %s -> %s", header.Filename, encodedLogPath)
+	adhoc, err := saveAdhocELF(r, workDir)
+	if err != nil {
+		fail(decodeErrorStatus(err), decodeErrorCode(err), err.Error())
 		return
 	}
-	out.Close()
-	log.Printf("Received upload file %s -> %s", header.Filename, encodedLogPath)
 
-	// Determine pushtag (optional; default to 'auto' when omitted)
-	pushtag := r.FormValue("pushtag")
-	if pushtag == "" {
-		pushtag = "auto"
+	owner := principalFromRequest(r).Username
+	res, err := runDecode(r.Context(), decodeRequest{
+		WorkDir: workDir,
+		LogPath: encodedLogPath,
+		Select:  sel,
+		Options: opts,
+		ELF:     adhoc,
+		Owner:   owner,
+	})
+	// Expose build id for easier debugging (client can read from headers on both success/failure)
+	if res.BuildID != "" {
+		w.Header().Set("X-Build-Id", res.BuildID)
 	}
-	// Determine buildID: prefer provided value, otherwise extract from uploaded log
-	buildID := r.FormValue("buildId")
-	if buildID == "" {
-		var err error
-		buildID, err = extractBuildIDFromEncodedLog(encodedLogPath)
-		if err != nil || buildID == "" {
-			http.Error(w, "Failed to extract Build ID from uploaded log.", http.StatusBadRequest)
-			return
-		}
+	if len(res.Warnings) > 0 {
+		w.Header().Set("X-Decode-Warning", strings.Join(res.Warnings, "; "))
 	}
-	// Expose build id for easier debugging (client can read from headers on both success/failure)
-	w.Header().Set("X-Build-Id", buildID)
-	log.Printf("Decoded BuildID from log: %s", buildID)
-
-	// Load ELF by buildId from DB
-	elfFileName, elfBlob, err := getELFByBuildID(buildID)
+	// Headers go out with the response, i.e. after the decode: they tell a synchronous caller how
+	// long it queued, not where it stands. Live positions are only available via /api/decode/jobs.
+	if res.QueuePosition > 0 {
+		w.Header().Set("X-Decoder-Queue-Position", strconv.Itoa(res.QueuePosition))
+		w.Header().Set("X-Decoder-Queue-Wait-Ms", strconv.FormatInt(res.QueueWait.Milliseconds(), 10))
+	}
+	status := http.StatusOK
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, fmt.Sprintf("ELF not found for buildId %s. Please upload/fetch the matching ELF in Admin.", buildID), http.StatusNotFound)
+		status = decodeErrorStatus(err)
+		if status == http.StatusTooManyRequests {
+			setRetryAfter(w)
+		}
+		w.Header().Set("X-Decode-Error", decodeErrorCode(err))
+		if !asJSON {
+			http.Error(w, err.Error(), status)
 			return
 		}
-		log.Printf("DB error while fetching ELF by buildId %s: %v", buildID, err)
-		http.Error(w, "Internal server error: database failure while fetching ELF.", http.StatusInternalServerError)
-		return
 	}
-	elfPath := filepath.Join(workDir, elfFileName)
-	if err := os.WriteFile(elfPath, elfBlob, 0644); err != nil {
-		http.Error(w, "Internal server error: Cannot write ELF temp file.", http.StatusInternalServerError)
-		return
+	if res.ElfFileName != "" {
+		w.Header().Set("X-ELF-File", res.ElfFileName)
 	}
 
-	// Execute nvlog_decoder decode
-	decodedLogFile := filepath.Join(workDir, "dce-decoded.log")
-	// Important: -e expects a valid file path or base path. Our stored filename may already
-	// include suffixes like "__<pushtag>__<buildId>". Passing additional suffixes would break the path.
-	// Therefore, pass the exact path we just wrote.
-	elfParam := elfPath
-	decoderCmd := exec.Command(
-		"nvlog_decoder",
-		"-d", "none",
-		"-i", encodedLogPath,
-		"-o", decodedLogFile,
-		"-e", elfParam,
-		"-f", "DCE",
-	)
-	if out, err := decoderCmd.CombinedOutput(); err != nil {
-		log.Printf("nvlog_decoder failed (buildId=%s, pushtag=%s): %v\nCmdOut:\n%s", buildID, pushtag, err, string(out))
-		http.Error(w, "Error: Log decoder tool failed to run or produced an error.", http.StatusInternalServerError)
+	if asJSON {
+		resp := newDecodeResponse(res, err, sel)
+		if err == nil {
+			keep := func() string {
+				keptWorkDir = true
+				job := decodeJobs.keepResult(owner, header.Filename, workDir, sel.Pushtag, res)
+				return decodeJobURL(job.ID, "/result")
+			}
+			if err := attachDecodedLog(&resp, res.OutputPath, keep); err != nil {
+				log.Printf("Reading decoded log %s failed: %v", res.OutputPath, err)
+				fail(http.StatusInternalServerError, codeInternal, "Internal server error: Cannot read decoded log.")
+				return
+			}
+		}
+		resp.Timings.TotalMs = sinceMs(started)
+		writeDecodeResponse(w, status, resp)
 		return
 	}
-	// Ensure output file exists and is non-empty; nvlog_decoder might exit 0 but not write output
-	if fi, err := os.Stat(decodedLogFile); err != nil || fi.Size() == 0 {
-		log.Printf("nvlog_decoder produced no output file (buildId=%s, pushtag=%s). Path=%s err=%v size=%d",
-			buildID, pushtag, decodedLogFile, err, func() int64 { if err == nil { return fi.Size() }; return -1 }())
-		http.Error(w, "Error: Decoder produced no output. Please verify input log and ELF mapping.", http.StatusInternalServerError)
+
+	if output == outputNDJSON || output == outputCSV {
+		if err := writeDecodedRecords(w, res.OutputPath, output); err != nil {
+			log.Printf("Reading decoded log %s failed: %v", res.OutputPath, err)
+			http.Error(w, "Internal server error: Cannot read decoded log.", http.StatusInternalServerError)
+		}
 		return
 	}
-
 	// Return dce-decoded.log as downloadable attachment
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Disposition", "attachment; filename=\"dce-decoded.log\"")
-	w.Header().Set("X-ELF-File", filepath.Base(elfPath))
-	http.ServeFile(w, r, decodedLogFile)
+	http.ServeFile(w, r, res.OutputPath)
 }
 
+// saveUpload copies an uploaded file to path.
+func saveUpload(src io.Reader, path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
 
+// adhocELF is an ELF uploaded with a decode request (multipart part `elf`). It is used for
+// that request only and never stored in build_elves.
+type adhocELF struct {
+	Path    string
+	BuildID string // "" if the ELF has no GNU build-id note
+}
+
+// saveAdhocELF saves the optional `elf` part of r to workDir/elf/ and reads its Build ID.
+// It returns nil when the request carries no ELF; errors are *decodeError.
+func saveAdhocELF(r *http.Request, workDir string) (*adhocELF, error) {
+	f, fh, err := r.FormFile("elf")
+	if err == http.ErrMissingFile {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &decodeError{http.StatusBadRequest, codeInvalidELF, "Invalid 'elf' part."}
+	}
+	defer f.Close()
+	name := filepath.Base(fh.Filename)
+	if name == "." || name == "/" || strings.HasPrefix(name, ".") {
+		name = "adhoc.elf"
+	}
+	dir := filepath.Join(workDir, "elf")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, &decodeError{http.StatusInternalServerError, codeInternal, "Internal server error: Cannot create ELF directory."}
+	}
+	path := filepath.Join(dir, name)
+	if err := saveUpload(f, path); err != nil {
+		return nil, &decodeError{http.StatusInternalServerError, codeInternal, "Internal server error: Cannot write uploaded ELF."}
+	}
+	buildID, err := extractBuildIDFromELF(path)
+	if err != nil && !errors.Is(err, errNoBuildID) {
+		return nil, &decodeError{http.StatusBadRequest, codeInvalidELF, fmt.Sprintf("Rejected uploaded ELF: %v", err)}
+	}
+	log.Printf("Received ad-hoc ELF %s (buildId=%q)", name, buildID)
+	return &adhocELF{Path: path, BuildID: buildID}, nil
+}
+
+// decodeError is a decode failure with the HTTP status to report it with and a stable code
+// for clients (also sent as X-Decode-Error); decoder_exec.go defines the decoder codes.
+type decodeError struct {
+	Status int
+	Code   string
+	Msg    string
+}
+
+func (e *decodeError) Error() string { return e.Msg }
+
+// decodeErrorStatus returns the HTTP status for an error from runDecode.
+func decodeErrorStatus(err error) int {
+	var de *decodeError
+	if errors.As(err, &de) {
+		return de.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// Error codes of decode failures outside the decoder itself.
+const (
+	codeInvalidLog  = "invalid_log"
+	codeNoBuildID   = "no_build_id"
+	codeELFMissing  = "elf_missing"
+	codeInvalidELF  = "invalid_elf"
+	codeELFMismatch = "elf_mismatch"
+	codeDecoderBusy = "decoder_busy"
+	codeInternal    = "internal_error"
+)
+
+// decodeErrorCode returns the code of an error from runDecode.
+func decodeErrorCode(err error) string {
+	var de *decodeError
+	if errors.As(err, &de) {
+		return de.Code
+	}
+	return codeInternal
+}
+
+// decodeRequest is the input of runDecode.
+type decodeRequest struct {
+	WorkDir  string // scratch directory; the ELF and the output are written here
+	LogPath  string
+	Select   elfSelection       // from parseELFSelection; the zero value means the log's Build ID
+	Options  decodeOptions      // from parseDecodeOptions or defaultDecodeOptions
+	ELF      *adhocELF          // ad-hoc ELF replacing the build_elves lookup; may be nil
+	Owner    string             // user shown on the decoder pool dashboard
+	Progress func(string)       // called before each stage; may be nil
+	OnQueued func(position int) // called while waiting for a decoder worker; may be nil
+}
+
+// decodeResult describes a decode; BuildID is set as soon as it is known, even on failure.
+type decodeResult struct {
+	BuildID     string
+	ElfFileName string
+	OutputPath  string
+	Warnings    []string    // e.g. an ELF whose Build ID differs from the log's
+	Decoder     *decoderRun // nil if the decoder did not run
+	// QueuePosition is the position on entering the decoder queue (0 = no wait)
+	QueuePosition int
+	QueueWait     time.Duration
+}
+
+// runDecode decodes req.LogPath: parse the header (checked against req.Options), select the ELF
+// (elf_select.go), wait for a decoder worker and run nvlog_decoder under the limits of
+// decoder_exec.go. The returned result is
+// never nil; errors are *decodeError (a full decoder queue is a 429).
+func runDecode(ctx context.Context, req decodeRequest) (*decodeResult, error) {
+	res := &decodeResult{}
+	step := func(msg string) {
+		if req.Progress != nil {
+			req.Progress(msg)
+		}
+	}
+	workDir, encodedLogPath, sel := req.WorkDir, req.LogPath, req.Select
+
+	opts := req.Options
+	step("Parsing log header...")
+	headerBuildID := ""
+	if opts.parsesHeader() {
+		// Parse the log header; this also rejects files the decoder could not read
+		logHeader, err := nvlog.ParseFile(encodedLogPath)
+		if err != nil {
+			return res, &decodeError{decodeHeaderErrorStatus(err), codeInvalidLog, fmt.Sprintf("Invalid log file: %v", err)}
+		}
+		if headerBuildID, err = opts.checkHeader(logHeader); err != nil {
+			return res, &decodeError{http.StatusUnprocessableEntity, codeInvalidLog, "Uploaded file does not match the decode options: " + err.Error()}
+		}
+		if headerBuildID == "" && req.ELF == nil && !sel.picksELF() {
+			return res, &decodeError{http.StatusUnprocessableEntity, codeNoBuildID, fmt.Sprintf("Log header (%s version %d) carries no Build ID.", logHeader.Container, logHeader.Version)}
+		}
+	} else if req.ELF == nil && !sel.picksELF() {
+		return res, &decodeError{http.StatusUnprocessableEntity, codeNoBuildID, "textDecode and singleBuffer inputs have no readable header; pass the ELF's buildId, elfFileName or pushtag."}
+	}
+	log.Printf("Decoded BuildID from log: %s", headerBuildID)
+	// Determine buildID: prefer provided value, otherwise take it from the log header
+	buildID := sel.BuildID
+	if buildID == "" {
+		buildID = headerBuildID
+	}
+	res.BuildID = buildID
+
+	// Pick the ELF before queueing so that a missing or ambiguous one fails fast
+	var stored ElfRecord
+	elfBuildID := ""
+	if req.ELF != nil {
+		elfBuildID = req.ELF.BuildID
+	} else {
+		var err error
+		if stored, err = resolveStoredELF(sel, buildID); err != nil {
+			return res, err
+		}
+		elfBuildID = stored.BuildID
+	}
+	// The log header is authoritative; without one, the requested Build ID is all we have
+	expected := headerBuildID
+	if expected == "" {
+		expected = sel.BuildID
+	}
+	if req.ELF != nil || sel.picksELF() {
+		if mismatch := elfMismatch(elfBuildID, expected); mismatch != "" {
+			if sel.FailMismatch {
+				return res, &decodeError{http.StatusUnprocessableEntity, codeELFMismatch, "ELF mismatch: " + mismatch + "."}
+			}
+			res.Warnings = append(res.Warnings, mismatch)
+		}
+	}
+	if elfBuildID != "" {
+		res.BuildID = elfBuildID
+	}
+
+	// The ELF blob is only materialised once a worker is free
+	queuedAt := time.Now()
+	release, err := decoders.acquire(ctx, req.Owner, func(position int) {
+		if res.QueuePosition == 0 {
+			res.QueuePosition = position
+		}
+		if req.OnQueued != nil {
+			req.OnQueued(position)
+		}
+	})
+	if err != nil {
+		if errors.Is(err, errDecoderBusy) {
+			return res, &decodeError{http.StatusTooManyRequests, codeDecoderBusy, "All decoders are busy and the queue is full; please retry later."}
+		}
+		return res, &decodeError{http.StatusServiceUnavailable, codeDecodeCancelled, "Decode cancelled while waiting for a decoder."}
+	}
+	defer release()
+	res.QueueWait = time.Since(queuedAt)
+
+	var elfPath string
+	if req.ELF != nil {
+		step("Using uploaded ELF...")
+		elfPath = req.ELF.Path
+	} else {
+		step("Loading ELF for Build ID " + stored.BuildID + "...")
+		// Load ELF by buildId from DB
+		_, elfBlob, err := getELFByBuildID(stored.BuildID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return res, &decodeError{http.StatusNotFound, codeELFMissing, fmt.Sprintf("ELF not found for buildId %s. Please upload/fetch the matching ELF in Admin.", stored.BuildID)}
+			}
+			log.Printf("DB error while fetching ELF by buildId %s: %v", stored.BuildID, err)
+			return res, &decodeError{http.StatusInternalServerError, codeInternal, "Internal server error: database failure while fetching ELF."}
+		}
+		elfPath = filepath.Join(workDir, filepath.Base(stored.ElfFileName))
+		if err := os.WriteFile(elfPath, elfBlob, 0644); err != nil {
+			return res, &decodeError{http.StatusInternalServerError, codeInternal, "Internal server error: Cannot write ELF temp file."}
+		}
+	}
+	res.ElfFileName = filepath.Base(elfPath)
+
+	step("Running nvlog_decoder...")
+	decodedLogFile := filepath.Join(workDir, "dce-decoded.log")
+	// Important: -e expects a valid file path or base path. Our stored filename may already
+	// include suffixes like "__<pushtag>__<buildId>". Passing additional suffixes would break the path.
+	// Therefore, pass the exact path we just wrote.
+	args := append(opts.args(filepath.Dir(elfPath)), "-i", encodedLogPath, "-o", decodedLogFile, "-e", elfPath)
+	run, err := runNvlogDecoder(ctx, decodedLogFile, []string{encodedLogPath, elfPath}, args...)
+	res.Decoder = &run
+	if err != nil {
+		log.Printf("nvlog_decoder failed (buildId=%s, pushtag=%s, elf=%s, %s): %v\nCmdOut:\n%s", res.BuildID, sel.Pushtag, res.ElfFileName, opts, err, string(run.Output))
+		return res, err
+	}
+	res.OutputPath = decodedLogFile
+	return res, nil
+}
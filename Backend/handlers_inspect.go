@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"decode-dce-log-service/backend/nvlog"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- Log inspection ----------------
+// ------------------------------------------------------------------------------------------------
+
+// inspectHandler parses the header of an uploaded log and looks up its ELF, without running
+// nvlog_decoder. POST multipart with field `file`.
+func inspectHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "Only POST requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseMultipartForm(100 << 20); err != nil {
+		writeJSONError(w, "Invalid multipart form data.", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSONError(w, "Missing uploaded file field 'file'.", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	setAuditTarget(r, "file="+header.Filename)
+
+	logHeader, err := nvlog.Parse(file, header.Size)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Invalid log file: %v", err), decodeHeaderErrorStatus(err))
+		return
+	}
+	resp := InspectResponse{Success: true, FileName: header.Filename, Header: logHeader}
+	switch {
+	case logHeader.BuildID == "":
+		resp.Message = "Log header carries no Build ID."
+	default:
+		name, err := getELFNameByBuildID(logHeader.BuildID)
+		switch {
+		case err == sql.ErrNoRows:
+			resp.Message = fmt.Sprintf("No ELF stored for buildId %s.", logHeader.BuildID)
+		case err != nil:
+			log.Printf("DB error while looking up ELF for buildId %s: %v", logHeader.BuildID, err)
+			writeJSONError(w, "Internal server error: database failure while looking up ELF.", http.StatusInternalServerError)
+			return
+		default:
+			resp.ELF = InspectELF{Found: true, ElfFileName: name, Pushtag: elfPushtag(name)}
+		}
+	}
+	if resp.ELF.Found {
+		resp.Decodable = true
+		resp.Message = "Log can be decoded."
+		if logHeader.Container == nvlog.ContainerDump {
+			resp.Format = formatNvlogDump
+			resp.Message = fmt.Sprintf("Full nvlog dump; decode it with format=%s (tag or bufferIndex select a buffer).", formatNvlogDump)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
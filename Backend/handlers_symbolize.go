@@ -0,0 +1,218 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- PC symbolization ----------------
+// ------------------------------------------------------------------------------------------------
+
+// POST /api/symbolize maps GSP/NVRISCV program counters to function, file and line using the
+// DWARF sections of a stored ELF, like addr2line: `nvlog_decoder -e <elf> -a "<pcs>"`. The
+// decoder runs in the worker pool under the same limits and sandbox as a log decode.
+
+// maxSymbolizePCs keeps the answer within the captured decoder output (decoderOutputCap).
+const maxSymbolizePCs = 100
+
+var (
+	pcPattern = regexp.MustCompile(`^(?:0[xX])?[0-9a-fA-F]{1,16}$`)
+	// nvlog_decoder prints "<task>: 0x<pc>: <symbol>" per PC and task, where <symbol> is
+	// "<function>+<offset> (<file>:<line>)" or "??? (<file>:<line>)"
+	pcLinePattern   = regexp.MustCompile(`^\s*(\S+): 0x([0-9a-fA-F]+): (.*)$`)
+	pcSymbolPattern = regexp.MustCompile(`^(?:(.+)\+(-?\d+)|\?\?\?) \((.*):(\d+)\)$`)
+)
+
+// normalizePCs validates the requested PCs; entries may hold several PCs separated by
+// whitespace or commas. PCs are returned as lower-case "0x..." without duplicates.
+func normalizePCs(in []string) ([]string, error) {
+	var pcs []string
+	seen := map[string]bool{}
+	for _, entry := range in {
+		for _, f := range strings.FieldsFunc(entry, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r' }) {
+			if !pcPattern.MatchString(f) {
+				return nil, fmt.Errorf("invalid program counter %q (expected hex, e.g. 0x1a2b)", f)
+			}
+			v, _ := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(f), "0x"), 16, 64)
+			pc := "0x" + strconv.FormatUint(v, 16)
+			if !seen[pc] {
+				seen[pc] = true
+				pcs = append(pcs, pc)
+			}
+		}
+	}
+	if len(pcs) == 0 {
+		return nil, errors.New("no program counters given")
+	}
+	if len(pcs) > maxSymbolizePCs {
+		return nil, fmt.Errorf("too many program counters (%d, at most %d)", len(pcs), maxSymbolizePCs)
+	}
+	return pcs, nil
+}
+
+// parseSymbolizeOutput collects the decoder's answer for each of pcs; lines it does not
+// recognise (warnings, banners) are ignored.
+func parseSymbolizeOutput(output string, pcs []string) []PCSymbol {
+	symbols := make([]PCSymbol, len(pcs))
+	index := map[string]int{}
+	for i, pc := range pcs {
+		symbols[i] = PCSymbol{PC: pc, Locations: []PCLocation{}}
+		index[pc] = i
+	}
+	for _, line := range strings.Split(output, "\n") {
+		m := pcLinePattern.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil {
+			continue
+		}
+		v, err := strconv.ParseUint(m[2], 16, 64)
+		if err != nil {
+			continue
+		}
+		i, ok := index["0x"+strconv.FormatUint(v, 16)]
+		if !ok {
+			continue
+		}
+		sm := pcSymbolPattern.FindStringSubmatch(strings.TrimSpace(m[3]))
+		if sm == nil {
+			continue
+		}
+		loc := PCLocation{Task: m[1], Function: sm[1], File: sm[3]}
+		loc.Offset, _ = strconv.ParseInt(sm[2], 10, 64)
+		loc.Line, _ = strconv.ParseInt(sm[4], 10, 64)
+		symbols[i].Locations = append(symbols[i].Locations, loc)
+		if loc.Function != "" || loc.Line > 0 {
+			symbols[i].Resolved = true
+		}
+	}
+	return symbols
+}
+
+// symbolizeHandler symbolizes program counters against a stored ELF.
+// POST JSON SymbolizeRequest -> SymbolizeResponse
+func symbolizeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "Only POST requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+	var req SymbolizeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid JSON body.", http.StatusBadRequest)
+		return
+	}
+	pcs, err := normalizePCs(req.PCs)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Tag != "" && !decodeTagPattern.MatchString(req.Tag) {
+		writeJSONError(w, fmt.Sprintf("Invalid tag %q.", req.Tag), http.StatusBadRequest)
+		return
+	}
+	req.BuildID = strings.ToLower(strings.TrimSpace(req.BuildID))
+	req.ElfFileName = strings.TrimSpace(req.ElfFileName)
+	if (req.BuildID == "") == (req.ElfFileName == "") {
+		writeJSONError(w, "Provide either buildId or elfFileName.", http.StatusBadRequest)
+		return
+	}
+	if req.BuildID != "" {
+		setAuditTarget(r, "buildId="+req.BuildID)
+	} else {
+		setAuditTarget(r, "elfFileName="+req.ElfFileName)
+	}
+	// Pick the ELF before queueing so that a missing or ambiguous one fails fast
+	buildID, elfFileName := req.BuildID, req.ElfFileName
+	if elfFileName != "" {
+		// several ELFs may share the name: 409 elf_ambiguous with the candidates
+		rec, err := resolveStoredELF(elfSelection{ElfFileName: elfFileName}, "")
+		if err != nil {
+			payload := map[string]interface{}{"success": false, "message": err.Error(), "errorCode": decodeErrorCode(err)}
+			if candidates := elfCandidates(err); len(candidates) > 0 {
+				payload["candidates"] = candidates
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(decodeErrorStatus(err))
+			json.NewEncoder(w).Encode(payload)
+			return
+		}
+		buildID = rec.BuildID
+	} else if _, err := getELFNameByBuildID(buildID); err != nil {
+		symbolizeELFError(w, req, err)
+		return
+	}
+
+	if decoders.full() {
+		setRetryAfter(w)
+		writeJSONError(w, "All decoders are busy and the queue is full; please retry later.", http.StatusTooManyRequests)
+		return
+	}
+	release, err := decoders.acquire(r.Context(), principalFromRequest(r).Username, nil)
+	if err != nil {
+		if errors.Is(err, errDecoderBusy) {
+			setRetryAfter(w)
+			writeJSONError(w, "All decoders are busy and the queue is full; please retry later.", http.StatusTooManyRequests)
+			return
+		}
+		writeJSONError(w, "Request cancelled while waiting for a decoder.", http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	// The ELF blob is only materialised once a worker is free
+	var blob []byte
+	elfFileName, blob, err = getELFByBuildID(buildID)
+	if err != nil {
+		symbolizeELFError(w, req, err)
+		return
+	}
+	workDir, err := os.MkdirTemp("", "dce-symbolize-")
+	if err != nil {
+		log.Printf("Error creating temp dir: %v", err)
+		writeJSONError(w, "Internal server error: Cannot create temp directory.", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(workDir)
+	elfPath := filepath.Join(workDir, filepath.Base(elfFileName))
+	if err := os.WriteFile(elfPath, blob, 0644); err != nil {
+		writeJSONError(w, "Internal server error: Cannot write ELF temp file.", http.StatusInternalServerError)
+		return
+	}
+
+	args := []string{"-e", elfPath, "-a", strings.Join(pcs, " ")}
+	if req.Tag != "" {
+		args = append(args, "-t", req.Tag)
+	}
+	run, err := runNvlogDecoder(r.Context(), "", []string{elfPath}, args...)
+	if err != nil {
+		log.Printf("nvlog_decoder PC symbolization failed (buildId=%s): %v\nCmdOut:\n%s", buildID, err, string(run.Output))
+		w.Header().Set("X-Decode-Error", decodeErrorCode(err))
+		writeJSONError(w, err.Error(), decodeErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SymbolizeResponse{
+		Success:     true,
+		BuildID:     buildID,
+		ElfFileName: elfFileName,
+		Symbols:     parseSymbolizeOutput(string(run.Output), pcs),
+	})
+}
+
+// symbolizeELFError reports a failed ELF lookup: 404 if the ELF is not stored, else a database error.
+func symbolizeELFError(w http.ResponseWriter, req SymbolizeRequest, err error) {
+	if err == sql.ErrNoRows {
+		writeJSONError(w, "ELF not found. Please upload/fetch the matching ELF in Admin.", http.StatusNotFound)
+		return
+	}
+	log.Printf("DB error while fetching ELF for symbolization (buildId=%q, elfFileName=%q): %v", req.BuildID, req.ElfFileName, err)
+	writeJSONError(w, "Internal server error: database failure while fetching ELF.", http.StatusInternalServerError)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizePCs(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "single", in: []string{"0x1a2b"}, want: []string{"0x1a2b"}},
+		{name: "case and leading zeros", in: []string{"0X00001A2B", "ff"}, want: []string{"0x1a2b", "0xff"}},
+		{name: "separators", in: []string{"0x10, 0x20\t0x30\r\n0x40"}, want: []string{"0x10", "0x20", "0x30", "0x40"}},
+		{name: "duplicates", in: []string{"0x10", "0x010 0X10"}, want: []string{"0x10"}},
+		{name: "not hex", in: []string{"0x1g"}, wantErr: true},
+		{name: "too long", in: []string{"0x12345678123456781"}, wantErr: true},
+		{name: "empty", in: []string{" , "}, wantErr: true},
+		{name: "too many", in: []string{manyPCs(maxSymbolizePCs + 1)}, wantErr: true},
+		{name: "at the limit", in: []string{manyPCs(maxSymbolizePCs)}, want: strings.Fields(manyPCs(maxSymbolizePCs))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizePCs(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizePCs(%q) error = %v, want error %t", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizePCs(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// manyPCs returns n distinct PCs separated by spaces.
+func manyPCs(n int) string {
+	pcs := make([]string, n)
+	for i := range pcs {
+		pcs[i] = fmt.Sprintf("0x%x", 0x1000+i)
+	}
+	return strings.Join(pcs, " ")
+}
+
+func TestParseSymbolizeOutput(t *testing.T) {
+	// nvlog_decoder -e <elf> -a "0x1a2b 0x2000 0x3000 0x4000": "<task>: 0x<pc>: <symbol>" per PC
+	// and task, mixed with console chatter
+	output := strings.Join([]string{
+		"Loading ELF display-t234-dce-log.elf...",
+		"RM: 0x1a2b: dce_main+16 (src/dce_main.c:123)",
+		"DCE_TASK: 0x00001A2B: dce_task_entry+4 (src/task.c:55)\r",
+		"RM: 0x2000: ??? (??:0)",
+		"RM: 0x3000: ??? (src/vectors.S:12)",
+		"RM: 0x5000: other+0 (other.c:1)",
+		"RM: 0x4000: garbage",
+		"",
+	}, "\n")
+	got := parseSymbolizeOutput(output, []string{"0x1a2b", "0x2000", "0x3000", "0x4000"})
+	want := []PCSymbol{
+		{PC: "0x1a2b", Resolved: true, Locations: []PCLocation{
+			{Task: "RM", Function: "dce_main", Offset: 16, File: "src/dce_main.c", Line: 123},
+			{Task: "DCE_TASK", Function: "dce_task_entry", Offset: 4, File: "src/task.c", Line: 55},
+		}},
+		{PC: "0x2000", Locations: []PCLocation{{Task: "RM", File: "??"}}},
+		{PC: "0x3000", Resolved: true, Locations: []PCLocation{{Task: "RM", File: "src/vectors.S", Line: 12}}},
+		{PC: "0x4000", Locations: []PCLocation{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSymbolizeOutput\n got %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- JWT signing keyring ----------------
+// ------------------------------------------------------------------------------------------------
+
+// Access tokens carry a kid header naming the key that signed them. With JWT_KEYS_DIR set,
+// every file in the directory is a key whose kid is the file name without extension:
+//   - *.pem: RSA (RS256) or Ed25519 (EdDSA) key, private (can sign) or public (verify only)
+//   - *.hmac: raw HS256 secret
+//
+// JWT_ACTIVE_KID selects the signing key. To rotate, add the new key, switch JWT_ACTIVE_KID
+// and restart; keep the old key (its public half is enough) until its tokens have expired.
+// Without JWT_KEYS_DIR the keyring holds a single HS256 key from JWT_SECRET (kid "default").
+var (
+	jwtKeysDir   = getenv("JWT_KEYS_DIR", "")
+	jwtActiveKID = getenv("JWT_ACTIVE_KID", "")
+	// appEnv "dev" allows the built-in development secret; anything else refuses to start with it.
+	appEnv = getenv("APP_ENV", "production")
+)
+
+const (
+	defaultJWTSecret = "dev-secret"
+	defaultKID       = "default"
+)
+
+type jwtKey struct {
+	kid    string
+	method jwt.SigningMethod
+	sign   interface{} // nil for verify-only keys
+	verify interface{}
+}
+
+type jwtKeyring struct {
+	keys   map[string]*jwtKey
+	active *jwtKey
+}
+
+var keyring *jwtKeyring
+
+// initKeyring loads the signing keys; main refuses to start on error.
+func initKeyring() error {
+	if appEnv != "dev" && getenv("JWT_SECRET", defaultJWTSecret) == defaultJWTSecret {
+		// JWT_SECRET also signs the OIDC state cookie, so it is required with asymmetric keys too
+		return fmt.Errorf("JWT_SECRET is unset or the development default while APP_ENV=%q; set a strong secret or APP_ENV=dev", appEnv)
+	}
+	kr := &jwtKeyring{keys: map[string]*jwtKey{}}
+	if jwtKeysDir == "" {
+		secret := getJWTSecret()
+		kr.keys[defaultKID] = &jwtKey{kid: defaultKID, method: jwt.SigningMethodHS256, sign: secret, verify: secret}
+		kr.active = kr.keys[defaultKID]
+		keyring = kr
+		log.Printf("JWT keyring: single HS256 key from JWT_SECRET.")
+		return nil
+	}
+	entries, err := os.ReadDir(jwtKeysDir)
+	if err != nil {
+		return fmt.Errorf("read JWT_KEYS_DIR: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(e.Name())
+		if ext != ".pem" && ext != ".hmac" {
+			continue
+		}
+		kid := strings.TrimSuffix(e.Name(), ext)
+		data, err := os.ReadFile(filepath.Join(jwtKeysDir, e.Name()))
+		if err != nil {
+			return fmt.Errorf("read key %s: %w", e.Name(), err)
+		}
+		var k *jwtKey
+		if ext == ".hmac" {
+			k, err = hmacKey(kid, data)
+		} else {
+			k, err = pemKey(kid, data)
+		}
+		if err != nil {
+			return fmt.Errorf("key %s: %w", e.Name(), err)
+		}
+		kr.keys[kid] = k
+		log.Printf("JWT key %q: %s, fingerprint %s, can sign: %t.", kid, k.method.Alg(), keyFingerprint(k), k.sign != nil)
+	}
+	if len(kr.keys) == 0 {
+		return fmt.Errorf("JWT_KEYS_DIR %s contains no *.pem or *.hmac keys", jwtKeysDir)
+	}
+	active, ok := kr.keys[jwtActiveKID]
+	if !ok {
+		return fmt.Errorf("JWT_ACTIVE_KID %q not found in JWT_KEYS_DIR", jwtActiveKID)
+	}
+	if active.sign == nil {
+		return fmt.Errorf("JWT_ACTIVE_KID %q is a public key and cannot sign", jwtActiveKID)
+	}
+	kr.active = active
+	keyring = kr
+	log.Printf("JWT keyring: %d key(s) loaded, signing with %q.", len(kr.keys), active.kid)
+	return nil
+}
+
+func hmacKey(kid string, data []byte) (*jwtKey, error) {
+	secret := []byte(strings.TrimSpace(string(data)))
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("HS256 secret must be at least 32 bytes")
+	}
+	return &jwtKey{kid: kid, method: jwt.SigningMethodHS256, sign: secret, verify: secret}, nil
+}
+
+func pemKey(kid string, data []byte) (*jwtKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block")
+	}
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key must be at least 2048 bits")
+		}
+		return &jwtKey{kid: kid, method: jwt.SigningMethodRS256, sign: key, verify: &key.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &jwtKey{kid: kid, method: jwt.SigningMethodRS256, verify: key}, nil
+	case ed25519.PrivateKey:
+		return &jwtKey{kid: kid, method: jwt.SigningMethodEdDSA, sign: key, verify: key.Public()}, nil
+	case ed25519.PublicKey:
+		return &jwtKey{kid: kid, method: jwt.SigningMethodEdDSA, verify: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T (use RSA or Ed25519)", parsed)
+	}
+}
+
+// signAccessToken signs claims with the active key and sets the kid header.
+func signAccessToken(claims jwt.Claims) (string, error) {
+	tok := jwt.NewWithClaims(keyring.active.method, claims)
+	tok.Header["kid"] = keyring.active.kid
+	return tok.SignedString(keyring.active.sign)
+}
+
+// verificationKey is the jwt.Keyfunc for access tokens. The algorithm must match the key
+// named by kid, so a public RSA key can never be used as an HMAC secret. Tokens without a
+// kid predate the keyring and are checked against the "default" key if there is one.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = defaultKID
+	}
+	k, ok := keyring.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for kid %q", token.Method.Alg(), kid)
+	}
+	return k.verify, nil
+}
+
+// jwksHandler publishes the public halves of the asymmetric keys (HMAC keys are never
+// exposed) so other services can verify access tokens.
+func jwksHandler(w http.ResponseWriter, r *http.Request) {
+	kids := make([]string, 0, len(keyring.keys))
+	for kid := range keyring.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	keys := make([]map[string]string, 0, len(kids))
+	for _, kid := range kids {
+		if jwk := publicJWK(keyring.keys[kid]); jwk != nil {
+			keys = append(keys, jwk)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func publicJWK(k *jwtKey) map[string]string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := k.verify.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA", "use": "sig", "alg": k.method.Alg(), "kid": k.kid,
+			"n": b64(pub.N.Bytes()),
+			"e": b64(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP", "crv": "Ed25519", "use": "sig", "alg": k.method.Alg(), "kid": k.kid,
+			"x": b64(pub),
+		}
+	}
+	return nil
+}
+
+// keyFingerprint is logged so operators can match kids to key material without exposing it.
+func keyFingerprint(k *jwtKey) string {
+	der, err := x509.MarshalPKIXPublicKey(k.verify)
+	if err != nil {
+		return "hmac"
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:8])
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- Login throttling, lockout and history ----------------
+// ------------------------------------------------------------------------------------------------
+
+// Failed logins are counted per username and per client IP in login_throttle. After
+// LOGIN_BACKOFF_AFTER failures each further failure blocks the key for an exponentially
+// growing delay (LOGIN_BACKOFF_BASE * 2^n); at LOGIN_MAX_FAILURES (per username) or
+// LOGIN_IP_MAX_FAILURES (per IP) the key is locked for LOGIN_LOCKOUT_DURATION. Counters
+// of keys that are not locked reset after LOGIN_FAILURE_WINDOW without failures.
+var (
+	loginBackoffAfter       = parseIntEnv("LOGIN_BACKOFF_AFTER", 3)
+	loginMaxFailures        = parseIntEnv("LOGIN_MAX_FAILURES", 10)
+	loginIPMaxFailures      = parseIntEnv("LOGIN_IP_MAX_FAILURES", 50)
+	loginBackoffBase        = parseDurationEnv("LOGIN_BACKOFF_BASE", "1s")
+	loginLockoutDuration    = parseDurationEnv("LOGIN_LOCKOUT_DURATION", "15m")
+	loginFailureWindow      = parseDurationEnv("LOGIN_FAILURE_WINDOW", "15m")
+	loginHistoryRetention   = parseDurationEnv("LOGIN_HISTORY_RETENTION", "2160h")
+	loginThrottleJanitorInt = parseDurationEnv("LOGIN_THROTTLE_JANITOR_INTERVAL", "10m")
+)
+
+// Throttle key kinds.
+const (
+	throttleKindUser = "user"
+	throttleKindIP   = "ip"
+)
+
+// Login outcomes recorded in login_history.
+const (
+	loginResultSuccess = "success"
+	loginResultInvalid = "invalid_credentials"
+	loginResultLocked  = "locked"
+	// an SSO callback that did not end in a session (IdP error, bad state, refused account)
+	loginResultSSOFailed = "sso_failed"
+)
+
+func parseIntEnv(key string, def int) int {
+	v, err := strconv.Atoi(getenv(key, strconv.Itoa(def)))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// loginDelayAfter returns how long a key stays blocked after its n-th consecutive failure.
+func loginDelayAfter(n, maxFailures int) time.Duration {
+	if n >= maxFailures {
+		return loginLockoutDuration
+	}
+	if n < loginBackoffAfter {
+		return 0
+	}
+	d := time.Duration(float64(loginBackoffBase) * math.Pow(2, float64(n-loginBackoffAfter)))
+	if d > loginLockoutDuration {
+		d = loginLockoutDuration
+	}
+	return d
+}
+
+// loginBlockedFor reports how long the username or client IP is still blocked (0 if not).
+func loginBlockedFor(username, ip string) (time.Duration, error) {
+	var lockedUntil sql.NullTime
+	err := db.QueryRow(`
+		SELECT MAX(locked_until) FROM login_throttle
+		WHERE (kind = ? AND key_value = ?) OR (kind = ? AND key_value = ?)`,
+		throttleKindUser, username, throttleKindIP, ip).Scan(&lockedUntil)
+	if err != nil {
+		return 0, err
+	}
+	if !lockedUntil.Valid {
+		return 0, nil
+	}
+	return time.Until(lockedUntil.Time), nil
+}
+
+// recordLoginFailure bumps the failure counter of one key and applies the backoff/lockout.
+func recordLoginFailure(kind, key string, maxFailures int) error {
+	now := time.Now()
+	// failures is assigned first, so it still sees the previous last_failure_at
+	if _, err := db.Exec(`
+		INSERT INTO login_throttle (kind, key_value, failures, last_failure_at)
+		VALUES (?, ?, 1, ?)
+		ON DUPLICATE KEY UPDATE
+			failures = IF(last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?), 1, failures + 1),
+			last_failure_at = VALUES(last_failure_at)`,
+		kind, key, now, now.Add(-loginFailureWindow), now); err != nil {
+		return err
+	}
+	var failures int
+	if err := db.QueryRow("SELECT failures FROM login_throttle WHERE kind = ? AND key_value = ?", kind, key).Scan(&failures); err != nil {
+		return err
+	}
+	delay := loginDelayAfter(failures, maxFailures)
+	if delay <= 0 {
+		return nil
+	}
+	if failures == maxFailures {
+		log.Printf("Login lockout: %s '%s' locked for %s after %d failures.", kind, key, delay, failures)
+	}
+	_, err := db.Exec("UPDATE login_throttle SET locked_until = ? WHERE kind = ? AND key_value = ?", now.Add(delay), kind, key)
+	return err
+}
+
+// clearLoginFailures resets the username counter after a successful login. The IP counter
+// is left alone so one valid account cannot be used to reset a password-spraying source.
+func clearLoginFailures(username string) {
+	if _, err := db.Exec("DELETE FROM login_throttle WHERE kind = ? AND key_value = ?", throttleKindUser, username); err != nil {
+		log.Printf("Failed to reset login failures for %s: %v", username, err)
+	}
+}
+
+// recordLoginAttempt appends one row to login_history.
+func recordLoginAttempt(r *http.Request, username, result string) {
+	ua := r.UserAgent()
+	if len(ua) > 255 {
+		ua = ua[:255]
+	}
+	if _, err := db.Exec(`
+		INSERT INTO login_history (username, client_ip, user_agent, result)
+		VALUES (?, ?, ?, ?)`, username, clientIP(r), ua, result); err != nil {
+		log.Printf("Failed to record login attempt for %s: %v", username, err)
+	}
+}
+
+// writeLoginBlocked answers a throttled login with 429 and a Retry-After hint.
+func writeLoginBlocked(w http.ResponseWriter, retryAfter time.Duration) {
+	secs := int(math.Ceil(retryAfter.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(LoginResponse{
+		Message: fmt.Sprintf("Too many failed login attempts. Try again in %d seconds.", secs),
+		Success: false,
+	})
+}
+
+// LoginLockout is the admin API view of a throttled username or client IP.
+type LoginLockout struct {
+	Kind          string    `json:"kind"`
+	Key           string    `json:"key"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"lastFailureAt"`
+	LockedUntil   time.Time `json:"lockedUntil"`
+}
+
+// adminLockoutsHandler lists currently blocked usernames/IPs and unlocks them.
+// GET lists; DELETE ?kind=user|ip&key=<value> unlocks (and resets the failure counter).
+func adminLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := db.Query(`
+			SELECT kind, key_value, failures, last_failure_at, locked_until
+			FROM login_throttle WHERE locked_until > ?
+			ORDER BY locked_until DESC`, time.Now())
+		if err != nil {
+			writeJSONError(w, "Failed to fetch lockouts.", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		list := make([]LoginLockout, 0, 16)
+		for rows.Next() {
+			var l LoginLockout
+			if err := rows.Scan(&l.Kind, &l.Key, &l.Failures, &l.LastFailureAt, &l.LockedUntil); err != nil {
+				writeJSONError(w, "Failed to read lockouts.", http.StatusInternalServerError)
+				return
+			}
+			list = append(list, l)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "lockouts": list})
+		return
+	case http.MethodDelete:
+		kind := r.URL.Query().Get("kind")
+		key := r.URL.Query().Get("key")
+		if kind == "" {
+			kind = throttleKindUser
+		}
+		if (kind != throttleKindUser && kind != throttleKindIP) || key == "" {
+			writeJSONError(w, "Expected ?kind=user|ip&key=<username or IP>.", http.StatusBadRequest)
+			return
+		}
+		res, err := db.Exec("DELETE FROM login_throttle WHERE kind = ? AND key_value = ?", kind, key)
+		if err != nil {
+			writeJSONError(w, "Failed to unlock.", http.StatusInternalServerError)
+			return
+		}
+		if aff, _ := res.RowsAffected(); aff == 0 {
+			writeJSONError(w, "No failed attempts recorded for that key.", http.StatusNotFound)
+			return
+		}
+		log.Printf("Login lockout for %s '%s' cleared by %s.", kind, key, principalFromRequest(r).Username)
+		json.NewEncoder(w).Encode(ResponsePayload{Message: fmt.Sprintf("Unlocked %s '%s'.", kind, key), Success: true})
+		return
+	default:
+		writeJSONError(w, "Only GET and DELETE requests are supported.", http.StatusMethodNotAllowed)
+	}
+}
+
+// LoginHistoryEntry is one row of login_history.
+type LoginHistoryEntry struct {
+	Username  string    `json:"username"`
+	ClientIP  string    `json:"clientIp"`
+	UserAgent string    `json:"userAgent"`
+	Result    string    `json:"result"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// adminLoginHistoryHandler returns recent login attempts, newest first.
+// GET ?username=<name>&limit=<n> (default 100, max 1000)
+func adminLoginHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "Only GET requests are supported.", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSONError(w, "limit must be a positive integer.", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if limit > 1000 {
+		limit = 1000
+	}
+	query := "SELECT username, client_ip, user_agent, result, created_at FROM login_history"
+	args := []interface{}{}
+	if u := r.URL.Query().Get("username"); u != "" {
+		query += " WHERE username = ?"
+		args = append(args, u)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		writeJSONError(w, "Failed to fetch login history.", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	list := make([]LoginHistoryEntry, 0, limit)
+	for rows.Next() {
+		var e LoginHistoryEntry
+		if err := rows.Scan(&e.Username, &e.ClientIP, &e.UserAgent, &e.Result, &e.CreatedAt); err != nil {
+			writeJSONError(w, "Failed to read login history.", http.StatusInternalServerError)
+			return
+		}
+		list = append(list, e)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "history": list})
+}
+
+// startLoginThrottleJanitor drops stale counters and login history past its retention.
+func startLoginThrottleJanitor() {
+	ticker := time.NewTicker(loginThrottleJanitorInt)
+	go func() {
+		for range ticker.C {
+			now := time.Now()
+			if _, err := db.Exec(`
+				DELETE FROM login_throttle
+				WHERE last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)`,
+				now.Add(-loginFailureWindow), now); err != nil {
+				log.Printf("Login throttle janitor: %v", err)
+			}
+			if _, err := db.Exec("DELETE FROM login_history WHERE created_at < ?", now.Add(-loginHistoryRetention)); err != nil {
+				log.Printf("Login history janitor: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,334 @@
+// Package nvlog parses the headers of the binary logs consumed by nvlog_decoder, without
+// decoding any log records. Two containers are recognised:
+//
+//   - nvlog dump: the "NvLog dump." magic, a dump header, a buffer table and one
+//     NVLOG_BUFFER header (tag, format, size) per buffer, each followed by its data.
+//   - LIBOS buffer: a single LIBOS_LOG_NVLOG_BUFFER without an nvlog dump header, as
+//     extracted for DCE/GSP/PMU and decoded with `nvlog_decoder -f <type>`.
+//
+// The layouts mirror the structures nvlog_decoder reads; all fields are little-endian.
+package nvlog
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Sentinel errors; every parse error wraps one of them (use errors.Is).
+var (
+	// ErrBadMagic: the file is neither an nvlog dump nor a LIBOS buffer.
+	ErrBadMagic = errors.New("bad magic")
+	// ErrTruncated: the file ends inside a header or buffer.
+	ErrTruncated = errors.New("truncated header")
+	// ErrUnsupportedVersion: recognised container with a version the decoder cannot handle.
+	ErrUnsupportedVersion = errors.New("unsupported version")
+	// ErrMalformed: a header field is out of range.
+	ErrMalformed = errors.New("malformed header")
+)
+
+// Error describes where parsing failed.
+type Error struct {
+	Err    error // one of the sentinel errors above
+	Offset int64 // file offset of the offending field
+	Detail string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v at offset 0x%x: %s", e.Err, e.Offset, e.Detail)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func parseErr(err error, off int64, format string, args ...interface{}) error {
+	return &Error{Err: err, Offset: off, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Container identifies the file layout.
+type Container string
+
+const (
+	ContainerDump  Container = "nvlog-dump"
+	ContainerLibos Container = "libos-buffer"
+)
+
+// Format is the record format of a buffer inside an nvlog dump.
+type Format uint32
+
+const (
+	FormatPrintf   Format = 0
+	FormatLibos    Format = 1
+	FormatMemtrack Format = 2
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatPrintf:
+		return "printf"
+	case FormatLibos:
+		return "libos"
+	case FormatMemtrack:
+		return "memtrack"
+	}
+	return fmt.Sprintf("unknown(%d)", uint32(f))
+}
+
+// DumpMagic starts every nvlog dump (NUL-terminated, 12 bytes on disk).
+const DumpMagic = "NvLog dump."
+
+const (
+	dumpMagicSize     = 12
+	dumpHeaderSize    = 0x50
+	bufferHeaderSize  = 0x20
+	maxBufferSize     = 0xffffffd7 // larger sizes overflow the decoder's allocation
+	libosV1HeaderSize = 24
+	libosV2HeaderSize = 72
+	maxBuildIDLength  = 32
+	maxLibosVersion   = 0xff
+)
+
+// buffer table entry count per dump version (0xb may also use the short 16-entry table)
+var dumpTableEntries = map[uint32]int{0xb: 0x100, 0xc: 0x100, 0xd: 0xf00}
+
+// LibosHeader is the LIBOS_LOG_NVLOG_BUFFER header in front of LIBOS log data.
+type LibosHeader struct {
+	GPUArch    uint32 `json:"gpuArch"`
+	GPUImpl    uint32 `json:"gpuImpl"`
+	Version    uint32 `json:"version"`
+	TaskPrefix string `json:"taskPrefix"`
+	BuildID    string `json:"buildId,omitempty"` // lower-case hex; version 2 only
+	Flags      uint32 `json:"flags"`
+	HeaderSize int    `json:"headerSize"`
+}
+
+// Buffer is one NVLOG_BUFFER of an nvlog dump.
+type Buffer struct {
+	Index  int          `json:"index"`  // position in the dump's buffer table (decoder's buffer index)
+	Offset int64        `json:"offset"` // file offset of the buffer header
+	Tag    string       `json:"tag"`
+	Format string       `json:"format"`
+	Flags  uint32       `json:"flags"`
+	Size   uint32       `json:"size"` // data bytes following the header
+	Pos    uint32       `json:"pos"`
+	Libos  *LibosHeader `json:"libos,omitempty"` // for libos-format buffers
+}
+
+// Header is the parsed structure of a log file.
+type Header struct {
+	Container Container    `json:"container"`
+	Magic     string       `json:"magic,omitempty"` // DumpMagic for dumps
+	Version   uint32       `json:"version"`         // dump version, or LIBOS buffer version
+	BuildID   string       `json:"buildId,omitempty"`
+	Buffers   []Buffer     `json:"buffers,omitempty"`
+	Libos     *LibosHeader `json:"libos,omitempty"` // for LIBOS buffer files
+	Size      int64        `json:"size"`
+}
+
+// ParseFile parses the header of the log at path.
+func ParseFile(path string) (*Header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return Parse(f, fi.Size())
+}
+
+// Parse parses the header of a log of the given size.
+func Parse(r io.ReaderAt, size int64) (*Header, error) {
+	if size >= dumpMagicSize {
+		magic := make([]byte, dumpMagicSize)
+		if _, err := r.ReadAt(magic, 0); err != nil {
+			return nil, err
+		}
+		if string(magic) == DumpMagic+"\x00" {
+			return parseDump(r, size)
+		}
+	}
+	lh, err := parseLibos(r, 0, size)
+	if errors.Is(err, ErrBadMagic) {
+		return nil, parseErr(ErrBadMagic, 0, "neither an nvlog dump (%q) nor a LIBOS log buffer", DumpMagic)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Header{Container: ContainerLibos, Version: lh.Version, BuildID: lh.BuildID, Libos: lh, Size: size}, nil
+}
+
+func parseDump(r io.ReaderAt, size int64) (*Header, error) {
+	hdr := make([]byte, dumpHeaderSize)
+	if err := readAt(r, hdr, dumpMagicSize, size, "dump header"); err != nil {
+		return nil, err
+	}
+	h := &Header{Container: ContainerDump, Magic: DumpMagic, Version: le.Uint32(hdr[0:4]), Size: size}
+	entries, ok := dumpTableEntries[h.Version]
+	if !ok {
+		return nil, parseErr(ErrUnsupportedVersion, dumpMagicSize, "nvlog dump version 0x%x (supported: 0xb, 0xc, 0xd)", h.Version)
+	}
+	off := int64(dumpMagicSize + dumpHeaderSize)
+	table := make([]byte, 4*entries)
+	if err := readAt(r, table, off, size, "buffer table"); err != nil {
+		if h.Version != 0xb {
+			return nil, err
+		}
+		entries = 0x10
+	} else if h.Version == 0xb {
+		// Version 0xb dumps may have a 16-entry table; then entries 16+ are really buffer headers
+		for i := 0x10; i < entries; i++ {
+			if le.Uint32(table[4*i:]) != 0 {
+				entries = 0x10
+				break
+			}
+		}
+	}
+	if entries == 0x10 {
+		table = table[:4*0x10]
+		if err := readAt(r, table, off, size, "buffer table"); err != nil {
+			return nil, err
+		}
+	}
+	off += int64(len(table))
+
+	for i := 0; i < entries; i++ {
+		if le.Uint32(table[4*i:]) == 0 {
+			continue
+		}
+		bh := make([]byte, bufferHeaderSize)
+		if err := readAt(r, bh, off, size, fmt.Sprintf("header of buffer %d", i)); err != nil {
+			return nil, err
+		}
+		b := Buffer{
+			Index:  i,
+			Offset: off,
+			Size:   le.Uint32(bh[8:12]),
+			Tag:    tagString(bh[12:16]),
+			Flags:  le.Uint32(bh[16:20]),
+			Pos:    le.Uint32(bh[20:24]),
+		}
+		format := Format((b.Flags >> 8) & 0x7)
+		b.Format = format.String()
+		if b.Size > maxBufferSize {
+			return nil, parseErr(ErrMalformed, off+8, "buffer %d size %d is out of range", i, b.Size)
+		}
+		dataOff := off + bufferHeaderSize
+		if dataOff+int64(b.Size) > size {
+			return nil, parseErr(ErrTruncated, dataOff, "buffer %d (%s) declares %d data bytes, only %d present", i, b.Tag, b.Size, size-dataOff)
+		}
+		if format == FormatLibos {
+			lh, err := parseLibos(r, dataOff, dataOff+int64(b.Size))
+			if err != nil {
+				return nil, fmt.Errorf("buffer %d (%s): %w", i, b.Tag, err)
+			}
+			b.Libos = lh
+			if h.BuildID == "" {
+				h.BuildID = lh.BuildID
+			}
+		}
+		h.Buffers = append(h.Buffers, b)
+		off = dataOff + int64(b.Size)
+	}
+	return h, nil
+}
+
+// parseLibos parses a LIBOS_LOG_NVLOG_BUFFER header at off; end bounds the buffer.
+func parseLibos(r io.ReaderAt, off, end int64) (*LibosHeader, error) {
+	if end-off < libosV1HeaderSize {
+		if end-off == 0 {
+			return nil, parseErr(ErrTruncated, off, "empty log")
+		}
+		return nil, parseErr(ErrTruncated, off, "%d bytes is shorter than a LIBOS buffer header (%d)", end-off, libosV1HeaderSize)
+	}
+	b := make([]byte, libosV1HeaderSize)
+	if err := readAt(r, b, off, end, "LIBOS buffer header"); err != nil {
+		return nil, err
+	}
+	prefix := b[16:24]
+	if !isTaskPrefix(prefix) {
+		return nil, parseErr(ErrBadMagic, off+16, "not a LIBOS log buffer (task prefix %q is not text)", prefix)
+	}
+	// Versions are small integers; anything else means this is not a LIBOS header at all
+	if v := le.Uint32(b[8:12]); v > maxLibosVersion {
+		return nil, parseErr(ErrBadMagic, off+8, "not a LIBOS log buffer (version field 0x%x)", v)
+	}
+	lh := &LibosHeader{
+		GPUArch:    le.Uint32(b[0:4]),
+		GPUImpl:    le.Uint32(b[4:8]),
+		Version:    le.Uint32(b[8:12]),
+		TaskPrefix: strings.TrimRight(string(prefix), "\x00"),
+		HeaderSize: libosV1HeaderSize,
+	}
+	switch lh.Version {
+	case 1:
+		return lh, nil
+	case 2:
+	default:
+		return nil, parseErr(ErrUnsupportedVersion, off+8, "LIBOS buffer version %d (supported: 1, 2)", lh.Version)
+	}
+	b = make([]byte, libosV2HeaderSize)
+	if err := readAt(r, b, off, end, "LIBOS v2 buffer header"); err != nil {
+		return nil, err
+	}
+	idLen := le.Uint32(b[12:16])
+	if idLen == 0 || idLen > maxBuildIDLength {
+		return nil, parseErr(ErrMalformed, off+12, "build ID length %d (expected 1..%d)", idLen, maxBuildIDLength)
+	}
+	lh.BuildID = hex.EncodeToString(b[32 : 32+idLen])
+	lh.Flags = le.Uint32(b[64:68])
+	lh.HeaderSize = libosV2HeaderSize
+	return lh, nil
+}
+
+var le = binary.LittleEndian
+
+// readAt fills b from off, reporting ErrTruncated if the data would run past end.
+func readAt(r io.ReaderAt, b []byte, off, end int64, what string) error {
+	if off+int64(len(b)) > end {
+		return parseErr(ErrTruncated, off, "%s needs %d bytes, only %d present", what, len(b), max64(end-off, 0))
+	}
+	if _, err := r.ReadAt(b, off); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return parseErr(ErrTruncated, off, "%s: %v", what, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// isTaskPrefix accepts printable ASCII followed by NUL padding (possibly all NUL).
+func isTaskPrefix(b []byte) bool {
+	end := len(b)
+	for i, c := range b {
+		if c == 0 {
+			end = i
+			break
+		}
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	for _, c := range b[end:] {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func tagString(b []byte) string {
+	return strings.TrimRight(string(b), "\x00 ")
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,238 @@
+package nvlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// libosV1 returns a version-1 LIBOS_LOG_NVLOG_BUFFER header (or any version, for error cases).
+func libosV1(prefix string, version uint32) []byte {
+	b := make([]byte, libosV1HeaderSize)
+	binary.LittleEndian.PutUint32(b[0:], 0x230)
+	binary.LittleEndian.PutUint32(b[4:], 0x4)
+	binary.LittleEndian.PutUint32(b[8:], version)
+	copy(b[16:24], prefix)
+	return b
+}
+
+// libosV2 returns a version-2 header; idLen is written as given so it can be out of range.
+func libosV2(prefix string, idLen uint32, buildID []byte, flags uint32) []byte {
+	b := make([]byte, libosV2HeaderSize)
+	copy(b, libosV1(prefix, 2))
+	binary.LittleEndian.PutUint32(b[12:], idLen)
+	copy(b[32:64], buildID)
+	binary.LittleEndian.PutUint32(b[64:], flags)
+	return b
+}
+
+// testBuffer is one NVLOG_BUFFER of a dump built by dump.
+type testBuffer struct {
+	index int
+	tag   string
+	flags uint32
+	size  uint32 // declared data size; data is padded or cut to it unless truncated
+	data  []byte
+}
+
+// dump returns an nvlog dump of the given version with a table of entries entries.
+func dump(version uint32, entries int, bufs ...testBuffer) []byte {
+	var b bytes.Buffer
+	b.WriteString(DumpMagic + "\x00")
+	hdr := make([]byte, dumpHeaderSize)
+	binary.LittleEndian.PutUint32(hdr, version)
+	b.Write(hdr)
+	table := make([]byte, 4*entries)
+	for _, buf := range bufs {
+		binary.LittleEndian.PutUint32(table[4*buf.index:], uint32(buf.index+1))
+	}
+	b.Write(table)
+	for _, buf := range bufs {
+		bh := make([]byte, bufferHeaderSize)
+		binary.LittleEndian.PutUint32(bh[8:], buf.size)
+		copy(bh[12:16], buf.tag)
+		binary.LittleEndian.PutUint32(bh[16:], buf.flags)
+		binary.LittleEndian.PutUint32(bh[20:], 0x10)
+		b.Write(bh)
+		data := make([]byte, buf.size)
+		copy(data, buf.data)
+		b.Write(data)
+	}
+	return b.Bytes()
+}
+
+var testBuildID = []byte{0xde, 0xad, 0xbe, 0xef, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+
+const libosFlags = uint32(FormatLibos) << 8
+
+func TestParse(t *testing.T) {
+	v2 := libosV2("DCE", 20, testBuildID, 0x3)
+	v2Header := &LibosHeader{GPUArch: 0x230, GPUImpl: 0x4, Version: 2, TaskPrefix: "DCE", BuildID: "deadbeef00112233445566778899aabbccddeeff", Flags: 0x3, HeaderSize: libosV2HeaderSize}
+	maxID := bytes.Repeat([]byte{0xab}, maxBuildIDLength)
+	// A 0xb dump with a 16-entry table: the first buffer header follows at entry 16
+	short0xb := dump(0xb, 0x10, testBuffer{index: 2, tag: "RM", size: 0x400})
+	tests := []struct {
+		name string
+		in   []byte
+		want *Header
+	}{
+		{
+			name: "libos v1",
+			in:   append(libosV1("GSP", 1), "log data"...),
+			want: &Header{Container: ContainerLibos, Version: 1, Size: 32,
+				Libos: &LibosHeader{GPUArch: 0x230, GPUImpl: 0x4, Version: 1, TaskPrefix: "GSP", HeaderSize: libosV1HeaderSize}},
+		},
+		{
+			name: "libos v1 empty task prefix",
+			in:   libosV1("", 1),
+			want: &Header{Container: ContainerLibos, Version: 1, Size: 24,
+				Libos: &LibosHeader{GPUArch: 0x230, GPUImpl: 0x4, Version: 1, HeaderSize: libosV1HeaderSize}},
+		},
+		{
+			name: "libos v2",
+			in:   v2,
+			want: &Header{Container: ContainerLibos, Version: 2, BuildID: v2Header.BuildID, Size: 72, Libos: v2Header},
+		},
+		{
+			name: "libos v2 longest build ID",
+			in:   libosV2("PMU", maxBuildIDLength, maxID, 0),
+			want: &Header{Container: ContainerLibos, Version: 2, BuildID: strings.Repeat("ab", maxBuildIDLength), Size: 72,
+				Libos: &LibosHeader{GPUArch: 0x230, GPUImpl: 0x4, Version: 2, TaskPrefix: "PMU", BuildID: strings.Repeat("ab", maxBuildIDLength), HeaderSize: libosV2HeaderSize}},
+		},
+		{
+			name: "dump 0xc printf and libos buffers",
+			in: dump(0xc, 0x100,
+				testBuffer{index: 0, tag: "RM", size: 0x40},
+				testBuffer{index: 7, tag: "DCE", flags: libosFlags | 0x1, size: 0x80, data: v2},
+			),
+			want: &Header{Container: ContainerDump, Magic: DumpMagic, Version: 0xc, BuildID: v2Header.BuildID, Size: 0x45c + 0x20 + 0x40 + 0x20 + 0x80,
+				Buffers: []Buffer{
+					{Index: 0, Offset: 0x45c, Tag: "RM", Format: "printf", Size: 0x40, Pos: 0x10},
+					{Index: 7, Offset: 0x45c + 0x60, Tag: "DCE", Format: "libos", Flags: libosFlags | 0x1, Size: 0x80, Pos: 0x10, Libos: v2Header},
+				}},
+		},
+		{
+			name: "dump 0xd",
+			in:   dump(0xd, 0xf00, testBuffer{index: 0xeff, tag: "MEM", flags: uint32(FormatMemtrack) << 8, size: 8}),
+			want: &Header{Container: ContainerDump, Magic: DumpMagic, Version: 0xd, Size: 0x5c + 0x3c00 + 0x28,
+				Buffers: []Buffer{{Index: 0xeff, Offset: 0x5c + 0x3c00, Tag: "MEM", Format: "memtrack", Flags: uint32(FormatMemtrack) << 8, Size: 8, Pos: 0x10}}},
+		},
+		{
+			name: "dump without buffers",
+			in:   dump(0xc, 0x100),
+			want: &Header{Container: ContainerDump, Magic: DumpMagic, Version: 0xc, Size: 0x45c},
+		},
+		{
+			// entries 16+ all zero: the full table is kept
+			name: "dump 0xb 256-entry table",
+			in:   dump(0xb, 0x100, testBuffer{index: 0xf, tag: "RM", size: 4}),
+			want: &Header{Container: ContainerDump, Magic: DumpMagic, Version: 0xb, Size: 0x45c + 0x24,
+				Buffers: []Buffer{{Index: 0xf, Offset: 0x45c, Tag: "RM", Format: "printf", Size: 4, Pos: 0x10}}},
+		},
+		{
+			name: "dump 0xb 16-entry table, nonzero entries past 16",
+			in:   short0xb,
+			want: &Header{Container: ContainerDump, Magic: DumpMagic, Version: 0xb, Size: 0x9c + 0x420,
+				Buffers: []Buffer{{Index: 2, Offset: 0x9c, Tag: "RM", Format: "printf", Size: 0x400, Pos: 0x10}}},
+		},
+		{
+			name: "dump 0xb 16-entry table, too short for 256 entries",
+			in:   dump(0xb, 0x10, testBuffer{index: 0, tag: "RM", size: 0}),
+			want: &Header{Container: ContainerDump, Magic: DumpMagic, Version: 0xb, Size: 0x9c + 0x20,
+				Buffers: []Buffer{{Index: 0, Offset: 0x9c, Tag: "RM", Format: "printf", Pos: 0x10}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(bytes.NewReader(tt.in), int64(len(tt.in)))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse()\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	v2 := libosV2("DCE", 20, testBuildID, 0)
+	dumpC := dump(0xc, 0x100, testBuffer{index: 1, tag: "RM", size: 0x10})
+	withVersion := func(b []byte, v uint32) []byte {
+		b = append([]byte{}, b...)
+		binary.LittleEndian.PutUint32(b[dumpMagicSize:], v)
+		return b
+	}
+	withSize := func(b []byte, off int, size uint32) []byte {
+		b = append([]byte{}, b...)
+		binary.LittleEndian.PutUint32(b[off+8:], size)
+		return b
+	}
+	tests := []struct {
+		name    string
+		in      []byte
+		wantErr error
+		wantOff int64
+	}{
+		{name: "zero-length file", in: nil, wantErr: ErrTruncated, wantOff: 0},
+		{name: "shorter than a LIBOS header", in: []byte("NvLog"), wantErr: ErrTruncated, wantOff: 0},
+		{name: "binary garbage", in: bytes.Repeat([]byte{0xff}, 64), wantErr: ErrBadMagic, wantOff: 0},
+		{name: "text", in: []byte("this is a plain text log file, not binary\n"), wantErr: ErrBadMagic, wantOff: 0},
+		{name: "libos version out of range", in: libosV1("DCE", 0x100), wantErr: ErrBadMagic, wantOff: 0},
+		{name: "libos version 0", in: libosV1("DCE", 0), wantErr: ErrUnsupportedVersion, wantOff: 8},
+		{name: "libos version 3", in: libosV1("DCE", 3), wantErr: ErrUnsupportedVersion, wantOff: 8},
+		{name: "libos v2 truncated", in: v2[:libosV2HeaderSize-1], wantErr: ErrTruncated, wantOff: 0},
+		{name: "libos v2 only v1 header", in: v2[:libosV1HeaderSize], wantErr: ErrTruncated, wantOff: 0},
+		{name: "libos v2 build ID length 0", in: libosV2("DCE", 0, nil, 0), wantErr: ErrMalformed, wantOff: 12},
+		{name: "libos v2 build ID too long", in: libosV2("DCE", maxBuildIDLength+1, testBuildID, 0), wantErr: ErrMalformed, wantOff: 12},
+		{name: "dump magic only", in: dumpC[:dumpMagicSize], wantErr: ErrTruncated, wantOff: dumpMagicSize},
+		{name: "dump header truncated", in: dumpC[:dumpMagicSize+dumpHeaderSize-1], wantErr: ErrTruncated, wantOff: dumpMagicSize},
+		{name: "dump version 0xa", in: withVersion(dumpC, 0xa), wantErr: ErrUnsupportedVersion, wantOff: dumpMagicSize},
+		{name: "dump version 0xe", in: withVersion(dumpC, 0xe), wantErr: ErrUnsupportedVersion, wantOff: dumpMagicSize},
+		{name: "dump 0xc table truncated", in: dumpC[:0x5c+0x100], wantErr: ErrTruncated, wantOff: 0x5c},
+		{name: "dump 0xd table truncated", in: dump(0xd, 0x100), wantErr: ErrTruncated, wantOff: 0x5c},
+		{name: "dump 0xb table truncated below 16 entries", in: dump(0xb, 0x10)[:0x5c+0x3c], wantErr: ErrTruncated, wantOff: 0x5c},
+		{name: "buffer header truncated", in: dumpC[:0x45c+0x1f], wantErr: ErrTruncated, wantOff: 0x45c},
+		{name: "buffer data truncated", in: dumpC[:len(dumpC)-1], wantErr: ErrTruncated, wantOff: 0x47c},
+		{name: "buffer size out of range", in: withSize(dumpC, 0x45c, maxBufferSize+1), wantErr: ErrMalformed, wantOff: 0x464},
+		{name: "buffer size max, data missing", in: withSize(dumpC, 0x45c, maxBufferSize), wantErr: ErrTruncated, wantOff: 0x47c},
+		{
+			name:    "libos buffer shorter than its header",
+			in:      dump(0xc, 0x100, testBuffer{index: 0, tag: "DCE", flags: libosFlags, size: 0x10, data: v2}),
+			wantErr: ErrTruncated, wantOff: 0x47c,
+		},
+		{
+			name:    "libos buffer cut inside the v2 header",
+			in:      dump(0xc, 0x100, testBuffer{index: 0, tag: "DCE", flags: libosFlags, size: 0x40, data: v2}),
+			wantErr: ErrTruncated, wantOff: 0x47c,
+		},
+		{
+			name:    "libos buffer with bad build ID length",
+			in:      dump(0xc, 0x100, testBuffer{index: 0, tag: "DCE", flags: libosFlags, size: 0x80, data: libosV2("DCE", 0, nil, 0)}),
+			wantErr: ErrMalformed, wantOff: 0x47c + 12,
+		},
+		{
+			name:    "empty libos buffer",
+			in:      dump(0xc, 0x100, testBuffer{index: 0, tag: "DCE", flags: libosFlags}),
+			wantErr: ErrTruncated, wantOff: 0x47c,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := Parse(bytes.NewReader(tt.in), int64(len(tt.in)))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Parse() = %+v, %v; want error %v", h, err, tt.wantErr)
+			}
+			var pe *Error
+			if !errors.As(err, &pe) {
+				t.Fatalf("Parse() error %v is not an *Error", err)
+			}
+			if pe.Offset != tt.wantOff {
+				t.Errorf("Parse() error offset = 0x%x, want 0x%x (%v)", pe.Offset, tt.wantOff, err)
+			}
+		})
+	}
+}
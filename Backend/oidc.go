@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- OIDC single sign-on (authorization code + PKCE) ----------------
+// ------------------------------------------------------------------------------------------------
+
+// OIDC is enabled when OIDC_ISSUER is set. Successful logins receive the same access/refresh
+// tokens as /api/login; local accounts keep working as a break-glass path.
+var (
+	oidcIssuer        = getenv("OIDC_ISSUER", "")
+	oidcClientID      = getenv("OIDC_CLIENT_ID", "")
+	oidcClientSecret  = getenv("OIDC_CLIENT_SECRET", "")
+	oidcRedirectURL   = getenv("OIDC_REDIRECT_URL", "http://localhost:3000/api/auth/oidc/callback")
+	oidcScopes        = getenv("OIDC_SCOPES", "openid profile email")
+	oidcUsernameClaim = getenv("OIDC_USERNAME_CLAIM", "preferred_username")
+	oidcGroupsClaim   = getenv("OIDC_GROUPS_CLAIM", "groups")
+	oidcAdminGroups   = getenv("OIDC_ADMIN_GROUPS", "")
+	oidcPostLoginURL  = getenv("OIDC_POST_LOGIN_REDIRECT", "/")
+)
+
+const (
+	oidcStateCookie = "dce_oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+func oidcEnabled() bool {
+	return oidcIssuer != "" && oidcClientID != ""
+}
+
+// oidcClient lazily performs provider discovery so the backend can start while the IdP is down.
+type oidcClient struct {
+	mu       sync.Mutex
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+	oauth    *oauth2.Config
+}
+
+var oidcState oidcClient
+
+func (c *oidcClient) get() (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.provider != nil {
+		return c.oauth, c.verifier, nil
+	}
+	// The provider keeps using this context to refresh its JWKS, so it must outlive the request
+	provider, err := oidc.NewProvider(context.Background(), oidcIssuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	c.provider = provider
+	c.verifier = provider.Verifier(&oidc.Config{ClientID: oidcClientID})
+	c.oauth = &oauth2.Config{
+		ClientID:     oidcClientID,
+		ClientSecret: oidcClientSecret,
+		RedirectURL:  oidcRedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       strings.Fields(oidcScopes),
+	}
+	return c.oauth, c.verifier, nil
+}
+
+// oidcConfigHandler tells the frontend whether to offer SSO login.
+func oidcConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"enabled":  oidcEnabled(),
+		"loginUrl": "/api/auth/oidc/login",
+	})
+}
+
+// oidcLoginHandler starts the authorization-code flow. state, nonce and the PKCE verifier
+// travel in a short-lived signed cookie so any backend replica can finish the flow.
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		writeJSONError(w, "SSO is not configured.", http.StatusNotFound)
+		return
+	}
+	cfg, _, err := oidcState.get()
+	if err != nil {
+		log.Printf("OIDC login: %v", err)
+		writeJSONError(w, "Identity provider unavailable.", http.StatusBadGateway)
+		return
+	}
+	state, err := randomIDHex(16)
+	if err != nil {
+		writeJSONError(w, "Internal server error: state generation.", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomIDHex(16)
+	if err != nil {
+		writeJSONError(w, "Internal server error: nonce generation.", http.StatusInternalServerError)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+	cookie, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"exp":      jwt.NewNumericDate(time.Now().Add(oidcStateTTL)),
+	}).SignedString(getJWTSecret())
+	if err != nil {
+		writeJSONError(w, "Internal server error: state signing.", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    cookie,
+		Path:     "/api/auth/oidc",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, cfg.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), http.StatusFound)
+}
+
+// oidcCallbackHandler finishes the flow and hands the session to the SPA in the URL fragment.
+// It is a GET, which withAudit skips, so it writes its auth.oidc-login event itself; every
+// outcome also goes to login_history (username "" while the IdP has not named the user).
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	// status is what the attempt amounts to; the browser always gets a redirect
+	username, status := "", http.StatusUnauthorized
+	defer func() {
+		result := loginResultSSOFailed
+		if status == http.StatusFound {
+			result = loginResultSuccess
+		}
+		recordLoginAttempt(r, username, result)
+		writeAuditEvent(username, "auth.oidc-login", "", clientIP(r), auditResult(status), status)
+	}()
+	fail := func(msg string) {
+		http.Redirect(w, r, oidcPostLoginURL+"#"+url.Values{"oidcError": {msg}}.Encode(), http.StatusFound)
+	}
+	if !oidcEnabled() {
+		status = http.StatusNotFound
+		writeJSONError(w, "SSO is not configured.", http.StatusNotFound)
+		return
+	}
+	// One-shot state cookie
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/api/auth/oidc", MaxAge: -1})
+
+	if e := r.URL.Query().Get("error"); e != "" {
+		fail("Identity provider returned an error: " + e)
+		return
+	}
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		fail("SSO session expired. Please try again.")
+		return
+	}
+	stateTok, err := jwt.Parse(c.Value, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method")
+		}
+		return getJWTSecret(), nil
+	})
+	if err != nil || !stateTok.Valid {
+		fail("SSO session expired. Please try again.")
+		return
+	}
+	pending, _ := stateTok.Claims.(jwt.MapClaims)
+	state, _ := pending["state"].(string)
+	nonce, _ := pending["nonce"].(string)
+	verifier, _ := pending["verifier"].(string)
+	if state == "" || state != r.URL.Query().Get("state") {
+		fail("SSO state mismatch. Please try again.")
+		return
+	}
+
+	cfg, idVerifier, err := oidcState.get()
+	if err != nil {
+		log.Printf("OIDC callback: %v", err)
+		status = http.StatusBadGateway
+		fail("Identity provider unavailable.")
+		return
+	}
+	oauthTok, err := cfg.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		fail("SSO code exchange failed.")
+		return
+	}
+	rawIDToken, ok := oauthTok.Extra("id_token").(string)
+	if !ok {
+		fail("Identity provider did not return an id_token.")
+		return
+	}
+	idToken, err := idVerifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		log.Printf("OIDC id_token verification failed: %v", err)
+		fail("SSO token verification failed.")
+		return
+	}
+	if idToken.Nonce != nonce {
+		fail("SSO nonce mismatch. Please try again.")
+		return
+	}
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		fail("Invalid SSO claims.")
+		return
+	}
+	username = oidcUsername(claims, idToken.Subject)
+	role, mapped := oidcRoleFromGroups(claims)
+
+	userID, role, tokenVersion, err := provisionExternalUser("oidc", username, role, mapped)
+	if err != nil {
+		log.Printf("OIDC provisioning for %s failed: %v", username, err)
+		fail(err.Error())
+		return
+	}
+	resp, err := issueSession(userID, username, role, tokenVersion, "")
+	if err != nil {
+		log.Printf("Failed to issue session for %s: %v", username, err)
+		status = http.StatusInternalServerError
+		fail("Failed to issue token.")
+		return
+	}
+	status = http.StatusFound
+	log.Printf("OIDC login for %s (role %s).", username, role)
+	http.Redirect(w, r, oidcPostLoginURL+"#"+url.Values{
+		"token":        {resp.Token},
+		"refreshToken": {resp.RefreshToken},
+		"expiresIn":    {strconv.Itoa(resp.ExpiresIn)},
+		"role":         {resp.Role},
+		"permissions":  {strings.Join(resp.Permissions, ",")},
+		"username":     {username},
+	}.Encode(), http.StatusFound)
+}
+
+func oidcUsername(claims map[string]interface{}, subject string) string {
+	for _, key := range []string{oidcUsernameClaim, "email"} {
+		if v, ok := claims[key].(string); ok && v != "" {
+			return v
+		}
+	}
+	return subject
+}
+
+// oidcRoleFromGroups maps the configured groups claim to "admin" (any of OIDC_ADMIN_GROUPS) or
+// "user"; mapped is false for the "user" default, which no group grants.
+func oidcRoleFromGroups(claims map[string]interface{}) (role string, mapped bool) {
+	var groups []string
+	switch v := claims[oidcGroupsClaim].(type) {
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	case string:
+		groups = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+	for _, admin := range strings.Split(oidcAdminGroups, ",") {
+		admin = strings.TrimSpace(admin)
+		for _, g := range groups {
+			if admin != "" && g == admin {
+				return "admin", true
+			}
+		}
+	}
+	return "user", false
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- Password change & admin reset ----------------
+// ------------------------------------------------------------------------------------------------
+
+// passwordMinLength applies to self-service changes (admin-created passwords are not checked).
+var passwordMinLength = parseIntEnv("PASSWORD_MIN_LENGTH", 8)
+
+// passwordChangePath is the only endpoint reachable while must_change_password is set.
+const passwordChangePath = "/api/me/password"
+
+var errUserNotFound = errors.New("user not found")
+
+// ChangePasswordRequest is the body of POST /api/me/password.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// changePasswordHandler lets a local user change their own password. On success every
+// existing session of the user is revoked and a fresh one is returned.
+func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "Only POST requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+	principal := principalFromRequest(r)
+	if principal.APITokenID != "" {
+		writeJSONError(w, "Passwords cannot be changed with an API token; log in first.", http.StatusForbidden)
+		return
+	}
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+	if len(req.NewPassword) < passwordMinLength {
+		writeJSONError(w, fmt.Sprintf("New password must be at least %d characters.", passwordMinLength), http.StatusBadRequest)
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		writeJSONError(w, "New password must differ from the current one.", http.StatusBadRequest)
+		return
+	}
+
+	var userID, stored, source, role string
+	err := db.QueryRow("SELECT id, password, auth_source, role FROM users WHERE username = ?", principal.Username).
+		Scan(&userID, &stored, &source, &role)
+	if err != nil {
+		writeJSONError(w, "Unauthorized.", http.StatusUnauthorized)
+		return
+	}
+	if source != "local" {
+		writeJSONError(w, fmt.Sprintf("Password is managed by %s; change it there.", source), http.StatusBadRequest)
+		return
+	}
+	if ok, _ := verifyPassword(stored, req.CurrentPassword); !ok {
+		writeJSONError(w, "Current password is incorrect.", http.StatusForbidden)
+		return
+	}
+	newHash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		writeJSONError(w, "Internal server error: password hashing.", http.StatusInternalServerError)
+		return
+	}
+	// Conditional on the old hash so two concurrent changes cannot both succeed
+	res, err := db.Exec("UPDATE users SET password = ?, must_change_password = FALSE WHERE id = ? AND password = ?", newHash, userID, stored)
+	if err != nil {
+		writeJSONError(w, "Failed to change password.", http.StatusInternalServerError)
+		return
+	}
+	if aff, _ := res.RowsAffected(); aff == 0 {
+		writeJSONError(w, "Password was changed concurrently; please try again.", http.StatusConflict)
+		return
+	}
+	if err := revokeUserSessions(userID); err != nil {
+		log.Printf("Failed to revoke sessions of %s after password change: %v", principal.Username, err)
+	}
+	var tokenVersion int
+	if err := db.QueryRow("SELECT token_version FROM users WHERE id = ?", userID).Scan(&tokenVersion); err != nil {
+		writeJSONError(w, "Password changed; please log in again.", http.StatusInternalServerError)
+		return
+	}
+	resp, err := issueSession(userID, principal.Username, role, tokenVersion, "")
+	if err != nil {
+		log.Printf("Failed to issue session for %s: %v", principal.Username, err)
+		writeJSONError(w, "Password changed; please log in again.", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %s changed their password.", principal.Username)
+	resp.Message = "Password changed."
+	json.NewEncoder(w).Encode(resp)
+}
+
+// resetUserPassword sets a random one-time password for a local user, forces a change on
+// next login and revokes the user's sessions. The temporary password is returned once.
+func resetUserPassword(userID string) (username, tempPassword string, err error) {
+	var source string
+	if err := db.QueryRow("SELECT username, auth_source FROM users WHERE id = ?", userID).Scan(&username, &source); err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", errUserNotFound
+		}
+		return "", "", fmt.Errorf("database failure")
+	}
+	if source != "local" {
+		return "", "", fmt.Errorf("password of %s user '%s' is managed externally", source, username)
+	}
+	tempPassword, err = randomIDHex(9)
+	if err != nil {
+		return "", "", fmt.Errorf("password generation failed")
+	}
+	hash, err := hashPassword(tempPassword)
+	if err != nil {
+		return "", "", fmt.Errorf("password hashing failed")
+	}
+	if _, err := db.Exec("UPDATE users SET password = ?, must_change_password = TRUE WHERE id = ?", hash, userID); err != nil {
+		return "", "", fmt.Errorf("failed to update password")
+	}
+	if err := revokeUserSessions(userID); err != nil {
+		log.Printf("Failed to revoke sessions of %s after password reset: %v", username, err)
+	}
+	return username, tempPassword, nil
+}
+
+// adminResetPasswordHandler issues a temporary password for a user.
+// POST JSON {id}
+func adminResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "Only POST requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		writeJSONError(w, "Missing user id.", http.StatusBadRequest)
+		return
+	}
+	setAuditTarget(r, "id="+req.ID)
+	username, temp, err := resetUserPassword(req.ID)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errUserNotFound) {
+			status = http.StatusNotFound
+		}
+		writeJSONError(w, fmt.Sprintf("Failed to reset password: %v", err), status)
+		return
+	}
+	log.Printf("Password of %s reset by %s.", username, principalFromRequest(r).Username)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":           true,
+		"message":           fmt.Sprintf("Temporary password set for '%s'. It must be changed at next login.", username),
+		"temporaryPassword": temp,
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- Password Hashing ----------------
+// ------------------------------------------------------------------------------------------------
+
+// Stored passwords carry a "<scheme>$" prefix so the hashing algorithm can change later
+// without guessing the format of existing rows. Rows without a known prefix are legacy
+// plaintext values and are upgraded on the user's next successful login.
+const (
+	passwordSchemeBcrypt = "bcrypt"
+	passwordSchemeNone   = "none"
+	passwordBcryptCost   = 12
+)
+
+// unusablePassword is stored for accounts that authenticate elsewhere (e.g. SSO); it never verifies.
+const unusablePassword = passwordSchemeNone + "$"
+
+// dummyPasswordHash is compared against when a username does not exist, so the response
+// time does not reveal which usernames are valid.
+var dummyPasswordHash, _ = hashPassword("dce-dummy-password")
+
+// hashPassword returns the prefixed hash to store in users.password.
+func hashPassword(plain string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(plain), passwordBcryptCost)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt: %w", err)
+	}
+	return passwordSchemeBcrypt + "$" + string(h), nil
+}
+
+// verifyPassword checks plain against a stored value. needsRehash is true when the
+// stored value matched but is not in the current format (legacy plaintext or an old cost).
+func verifyPassword(stored, plain string) (ok bool, needsRehash bool) {
+	scheme, hash, found := strings.Cut(stored, "$")
+	if found && scheme == passwordSchemeNone {
+		return false, false
+	}
+	if found && scheme == passwordSchemeBcrypt {
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain)); err != nil {
+			return false, false
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return true, err != nil || cost != passwordBcryptCost
+	}
+	// Legacy plaintext row
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(plain)) == 1 {
+		return true, true
+	}
+	return false, false
+}
+
+// upgradePasswordHash re-hashes a user's password after a successful login. The update is
+// conditional on the old value so a concurrent password change is never overwritten.
+func upgradePasswordHash(username, oldStored, plain string) error {
+	newHash, err := hashPassword(plain)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE users SET password = ? WHERE username = ? AND password = ?", newHash, username, oldStored)
+	return err
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- Roles & Permissions ----------------
+// ------------------------------------------------------------------------------------------------
+
+// Named permissions guarding the API. Role -> permission mappings live in role_permissions
+// and are editable through /api/admin/roles; they are resolved on every request, so edits
+// take effect immediately.
+const (
+	permDecode     = "decode"
+	permElvesRead  = "elves:read"
+	permElvesWrite = "elves:write" // upload, by-URL fetch, delete
+	permUsersRead  = "users:read"
+	permUsersWrite = "users:write"
+	permRolesRead  = "roles:read"
+	permRolesWrite = "roles:write"
+	permAuditRead  = "audit:read"
+	permStatsRead  = "stats:read" // decoder pool utilization
+)
+
+// allPermissions lists every permission known to the service.
+var allPermissions = []string{
+	permDecode,
+	permElvesRead, permElvesWrite,
+	permUsersRead, permUsersWrite,
+	permRolesRead, permRolesWrite,
+	permAuditRead,
+	permStatsRead,
+}
+
+// superuserRole always holds every permission and cannot be edited or deleted, so the
+// service can never be locked out of its own role management.
+const superuserRole = "admin"
+
+// defaultRoles are seeded on startup (existing mappings are left untouched).
+var defaultRoles = []struct {
+	Name        string
+	Description string
+	Permissions []string
+}{
+	{"admin", "Full access.", allPermissions},
+	{"user", "Decode logs.", []string{permDecode}},
+	{"decoder", "Decode logs.", []string{permDecode}},
+	{"elf-curator", "Manage the ELF library (upload, by-URL fetch, delete).", []string{permDecode, permElvesRead, permElvesWrite}},
+	{"user-admin", "Manage user accounts.", []string{permUsersRead, permUsersWrite}},
+	{"auditor", "Read-only access to everything.", []string{permElvesRead, permUsersRead, permRolesRead, permAuditRead, permStatsRead}},
+}
+
+func isKnownPermission(p string) bool {
+	for _, k := range allPermissions {
+		if k == p {
+			return true
+		}
+	}
+	return false
+}
+
+// seedRoles inserts the default roles and their permissions if missing. A role that already
+// exists keeps its permissions (an admin may have edited them), so a permission added to
+// defaultRoles later must also be granted to existing installations by a migration.
+func seedRoles() error {
+	for _, r := range defaultRoles {
+		res, err := db.Exec("INSERT IGNORE INTO roles (name, description) VALUES (?, ?)", r.Name, r.Description)
+		if err != nil {
+			return fmt.Errorf("seed role %s: %w", r.Name, err)
+		}
+		// Only populate permissions for roles created just now
+		if aff, _ := res.RowsAffected(); aff == 0 {
+			continue
+		}
+		for _, p := range r.Permissions {
+			if _, err := db.Exec("INSERT IGNORE INTO role_permissions (role, permission) VALUES (?, ?)", r.Name, p); err != nil {
+				return fmt.Errorf("seed role %s permission %s: %w", r.Name, p, err)
+			}
+		}
+	}
+	return nil
+}
+
+func roleExists(role string) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM roles WHERE name = ?", role).Scan(&n)
+	return n > 0, err
+}
+
+// rolePermissions returns the permissions granted to role.
+func rolePermissions(ctx context.Context, role string) ([]string, error) {
+	if role == superuserRole {
+		return append([]string(nil), allPermissions...), nil
+	}
+	rows, err := db.QueryContext(ctx, "SELECT permission FROM role_permissions WHERE role = ? ORDER BY permission", role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	perms := make([]string, 0, 8)
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		perms = append(perms, p)
+	}
+	return perms, rows.Err()
+}
+
+func roleHasPermission(ctx context.Context, role, perm string) (bool, error) {
+	if role == superuserRole {
+		return true, nil
+	}
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM role_permissions WHERE role = ? AND permission = ?", role, perm).Scan(&n)
+	return n > 0, err
+}
+
+// routePermissions maps an HTTP method to the permission it requires; the "" entry applies
+// to every method not listed explicitly.
+type routePermissions map[string]string
+
+// perm guards every method of a route with the same permission.
+func perm(p string) routePermissions {
+	return routePermissions{"": p}
+}
+
+func (rp routePermissions) forMethod(method string) string {
+	if p, ok := rp[method]; ok {
+		return p
+	}
+	return rp[""]
+}
+
+// withPermission authenticates the request (JWT or API token) and requires the caller's
+// role, and the API token scope if any, to grant the permission for the request method.
+func withPermission(perms routePermissions, next http.HandlerFunc) http.HandlerFunc {
+	return requireAuth(true, func(w http.ResponseWriter, r *http.Request) {
+		principal := principalFromRequest(r)
+		needed := perms.forMethod(r.Method)
+		ok, err := roleHasPermission(r.Context(), principal.Role, needed)
+		if err != nil {
+			log.Printf("Permission lookup failed (role=%s, perm=%s): %v", principal.Role, needed, err)
+			writeJSONError(w, "Internal server error: permission lookup.", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			writeJSONError(w, fmt.Sprintf("Forbidden: missing permission %q.", needed), http.StatusForbidden)
+			return
+		}
+		if !apiTokenScopeAllows(principal.Scope, needed) {
+			writeJSONError(w, "Forbidden: API token scope does not allow this endpoint.", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// RoleInfo is the admin API view of a role.
+type RoleInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// adminRolesHandler lists and edits role -> permission mappings.
+// GET lists roles; PUT JSON {name, description, permissions[]} creates or replaces a role;
+// DELETE ?name=<role> removes an unused role.
+func adminRolesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := db.Query("SELECT name, description FROM roles ORDER BY name")
+		if err != nil {
+			writeJSONError(w, "Failed to fetch roles.", http.StatusInternalServerError)
+			return
+		}
+		roles := make([]RoleInfo, 0, 16)
+		for rows.Next() {
+			var ri RoleInfo
+			if err := rows.Scan(&ri.Name, &ri.Description); err != nil {
+				rows.Close()
+				writeJSONError(w, "Failed to read roles.", http.StatusInternalServerError)
+				return
+			}
+			roles = append(roles, ri)
+		}
+		rows.Close()
+		for i := range roles {
+			perms, err := rolePermissions(r.Context(), roles[i].Name)
+			if err != nil {
+				writeJSONError(w, "Failed to read role permissions.", http.StatusInternalServerError)
+				return
+			}
+			roles[i].Permissions = perms
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"roles":       roles,
+			"permissions": allPermissions,
+		})
+		return
+	case http.MethodPut, http.MethodPost:
+		var req RoleInfo
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, "Invalid request body.", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		setAuditTarget(r, "name="+req.Name)
+		if req.Name == "" {
+			writeJSONError(w, "Role name cannot be empty.", http.StatusBadRequest)
+			return
+		}
+		if req.Name == superuserRole {
+			writeJSONError(w, fmt.Sprintf("Role '%s' always has every permission and cannot be edited.", superuserRole), http.StatusBadRequest)
+			return
+		}
+		for _, p := range req.Permissions {
+			if !isKnownPermission(p) {
+				writeJSONError(w, fmt.Sprintf("Unknown permission %q.", p), http.StatusBadRequest)
+				return
+			}
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			writeJSONError(w, "Failed to update role.", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec(`
+			INSERT INTO roles (name, description) VALUES (?, ?)
+			ON DUPLICATE KEY UPDATE description = VALUES(description)`, req.Name, req.Description); err != nil {
+			writeJSONError(w, "Failed to update role.", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.Exec("DELETE FROM role_permissions WHERE role = ?", req.Name); err != nil {
+			writeJSONError(w, "Failed to update role.", http.StatusInternalServerError)
+			return
+		}
+		sort.Strings(req.Permissions)
+		for _, p := range req.Permissions {
+			if _, err := tx.Exec("INSERT IGNORE INTO role_permissions (role, permission) VALUES (?, ?)", req.Name, p); err != nil {
+				writeJSONError(w, "Failed to update role.", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			writeJSONError(w, "Failed to update role.", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": fmt.Sprintf("Role '%s' saved.", req.Name),
+			"role":    req,
+		})
+		return
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			writeJSONError(w, "Missing role name.", http.StatusBadRequest)
+			return
+		}
+		if name == superuserRole {
+			writeJSONError(w, fmt.Sprintf("Role '%s' cannot be deleted.", superuserRole), http.StatusBadRequest)
+			return
+		}
+		var inUse int
+		if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", name).Scan(&inUse); err != nil {
+			writeJSONError(w, "Failed to delete role.", http.StatusInternalServerError)
+			return
+		}
+		if inUse > 0 {
+			writeJSONError(w, fmt.Sprintf("Role '%s' is still assigned to %d user(s).", name, inUse), http.StatusConflict)
+			return
+		}
+		res, err := db.Exec("DELETE FROM roles WHERE name = ?", name)
+		if err != nil {
+			writeJSONError(w, "Failed to delete role.", http.StatusInternalServerError)
+			return
+		}
+		if aff, _ := res.RowsAffected(); aff == 0 {
+			writeJSONError(w, "Role not found.", http.StatusNotFound)
+			return
+		}
+		if _, err := db.Exec("DELETE FROM role_permissions WHERE role = ?", name); err != nil {
+			log.Printf("Failed to delete permissions of role %s: %v", name, err)
+		}
+		json.NewEncoder(w).Encode(ResponsePayload{Message: "Role deleted.", Success: true})
+		return
+	default:
+		writeJSONError(w, "Only GET, PUT and DELETE requests are supported.", http.StatusMethodNotAllowed)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 )
 
 // ------------------------------------------------------------------------------------------------	
@@ -10,6 +11,14 @@ import (
 // ------------------------------------------------------------------------------------------------
 
 func main() {
+	// Re-executed as the nvlog_decoder sandbox helper (see decoder_sandbox.go)
+	if len(os.Args) > 1 && os.Args[1] == sandboxHelperArg {
+		runSandboxHelper(os.Args[2:])
+	}
+	// Load JWT signing keys (refuses the development secret outside APP_ENV=dev)
+	if err := initKeyring(); err != nil {
+		log.Fatalf("JWT keyring init failed: %v", err)
+	}
 	// Initialize database
 	if err := initDB(); err != nil {
 		log.Fatalf("DB init failed: %v", err)
@@ -17,23 +26,57 @@ func main() {
 
 	mux := http.NewServeMux()
 	// Register handlers
-	mux.HandleFunc("/api/decode", withAuth(decodeHandlerMultipart))
-	mux.HandleFunc("/api/login", loginHandler)
-	mux.HandleFunc("/api/admin/users", withAdmin(adminUsersHandler))
-	mux.HandleFunc("/api/admin/elves/by-url", withAdmin(adminElvesByURLHandler))
-	mux.HandleFunc("/api/admin/elves/by-url/start", withAdmin(adminElvesByURLStartHandler))
-	mux.HandleFunc("/api/admin/elves/by-url/status", withAdmin(adminElvesByURLStatusHandler))
-	mux.HandleFunc("/api/admin/elves/by-url/stream", withAdmin(adminElvesByURLStreamHandler))
-	mux.HandleFunc("/api/admin/elves/by-url/cancel", withAdmin(adminElvesByURLCancelHandler))
-	mux.HandleFunc("/api/admin/elves/by-url/clear", withAdmin(adminElvesByURLClearHandler))
-	mux.HandleFunc("/api/admin/elves/upload", withAdmin(adminElvesUploadHandler))
-	mux.HandleFunc("/api/admin/elves", withAdmin(adminElvesListHandler))
+	mux.HandleFunc("/api/decode", withAudit(action("decode"), withPermission(perm(permDecode), decodeHandlerMultipart)))
+	mux.HandleFunc("/api/decode/batch", withAudit(action("decode.batch"), withPermission(perm(permDecode), decodeBatchHandler)))
+	mux.HandleFunc("/api/decode/jobs", withAudit(action("decode.jobs.create"), withPermission(perm(permDecode), decodeJobsHandler)))
+	mux.HandleFunc("/api/decode/jobs/{id}", withAudit(action("decode.jobs.delete"), withPermission(perm(permDecode), decodeJobHandler)))
+	mux.HandleFunc("/api/decode/jobs/{id}/stream", withPermission(perm(permDecode), decodeJobStreamHandler))
+	mux.HandleFunc("/api/decode/jobs/{id}/result", withPermission(perm(permDecode), decodeJobResultHandler))
+	mux.HandleFunc("/api/inspect", withAudit(action("decode.inspect"), withPermission(perm(permDecode), inspectHandler)))
+	mux.HandleFunc("/api/symbolize", withAudit(action("decode.symbolize"), withPermission(perm(permDecode), symbolizeHandler)))
+	mux.HandleFunc("/api/login", withAudit(action("auth.login"), loginHandler))
+	mux.HandleFunc("/api/token/refresh", withAudit(action("auth.refresh"), refreshHandler))
+	mux.HandleFunc("/api/logout", withAudit(action("auth.logout"), logoutHandler))
+	mux.HandleFunc("/api/auth/oidc/config", oidcConfigHandler)
+	mux.HandleFunc("/api/auth/oidc/login", oidcLoginHandler)
+	// GET routes that act (OIDC callback, by-URL stream) write their audit events themselves
+	mux.HandleFunc("/api/auth/oidc/callback", oidcCallbackHandler)
+	mux.HandleFunc("/api/me/tokens", withAudit(routeActions{http.MethodPost: "tokens.create", http.MethodDelete: "tokens.revoke"}, withAuth(apiTokensHandler)))
+	mux.HandleFunc("/api/me/password", withAudit(action("password.change"), withAuth(changePasswordHandler)))
+	mux.HandleFunc("/api/admin/users", withAudit(routeActions{http.MethodPost: "users.create", http.MethodDelete: "users.delete", "": "users.update"},
+		withPermission(routePermissions{http.MethodGet: permUsersRead, "": permUsersWrite}, adminUsersHandler)))
+	mux.HandleFunc("/api/admin/users/reset-password", withAudit(action("users.reset-password"), withPermission(perm(permUsersWrite), adminResetPasswordHandler)))
+	mux.HandleFunc("/api/admin/lockouts", withAudit(action("lockouts.unlock"), withPermission(routePermissions{http.MethodGet: permUsersRead, "": permUsersWrite}, adminLockoutsHandler)))
+	mux.HandleFunc("/api/admin/login-history", withPermission(perm(permUsersRead), adminLoginHistoryHandler))
+	mux.HandleFunc("/api/admin/roles", withAudit(routeActions{http.MethodDelete: "roles.delete", "": "roles.save"},
+		withPermission(routePermissions{http.MethodGet: permRolesRead, "": permRolesWrite}, adminRolesHandler)))
+	mux.HandleFunc("/api/admin/elves/by-url", withAudit(action("elves.fetch-by-url"), withPermission(perm(permElvesWrite), adminElvesByURLHandler)))
+	mux.HandleFunc("/api/admin/elves/by-url/start", withAudit(action("elves.fetch-by-url.start"), withPermission(perm(permElvesWrite), adminElvesByURLStartHandler)))
+	mux.HandleFunc("/api/admin/elves/by-url/status", withPermission(perm(permElvesRead), adminElvesByURLStatusHandler))
+	// stream may also create a job from (pushtag,url) for backward compatibility
+	mux.HandleFunc("/api/admin/elves/by-url/stream", withPermission(perm(permElvesWrite), adminElvesByURLStreamHandler))
+	mux.HandleFunc("/api/admin/elves/by-url/cancel", withAudit(action("elves.fetch-by-url.cancel"), withPermission(perm(permElvesWrite), adminElvesByURLCancelHandler)))
+	mux.HandleFunc("/api/admin/elves/by-url/clear", withAudit(action("elves.fetch-by-url.clear"), withPermission(perm(permElvesWrite), adminElvesByURLClearHandler)))
+	mux.HandleFunc("/api/admin/elves/upload", withAudit(action("elves.upload"), withPermission(perm(permElvesWrite), adminElvesUploadHandler)))
+	mux.HandleFunc("/api/admin/elves", withAudit(action("elves.delete"), withPermission(routePermissions{http.MethodGet: permElvesRead, "": permElvesWrite}, adminElvesListHandler)))
+	mux.HandleFunc("/api/admin/audit", withPermission(perm(permAuditRead), adminAuditHandler))
+	mux.HandleFunc("/api/admin/decoder-pool", withPermission(perm(permStatsRead), adminDecoderPoolHandler))
+	mux.HandleFunc("/.well-known/jwks.json", jwksHandler)
 	mux.HandleFunc("/healthz", healthzHandler)
 
 	// Use mux directly (CORS handled via Nginx same-origin)
 	handler := mux
+	// Decide whether nvlog_decoder runs sandboxed (DECODER_SANDBOX) and report it
+	initDecoderSandbox()
+	log.Printf("Decoder limits: %s; workers: %d, queue: %d.", decoderLimitsSummary(), len(decoders.workers), decoders.maxQueue)
 	// Start background reaper for by-URL jobs (TTL cleanup)
 	startByURLJobReaper()
+	// Drop finished decode jobs and their results after DECODE_JOB_RESULT_TTL
+	startDecodeJobReaper()
+	// Drop expired refresh tokens and jti revocations
+	startSessionJanitor()
+	// Drop stale login failure counters and old login history
+	startLoginThrottleJanitor()
 	log.Println("Starting server on :8080...")
 	if err := http.ListenAndServe(":8080", handler); err != nil {
 		log.Fatalf("Server failed to start: %v", err)
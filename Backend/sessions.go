@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ------------------------------------------------------------------------------------------------
+// ---------------- Sessions: access/refresh tokens, logout, revocation ----------------
+// ------------------------------------------------------------------------------------------------
+
+// Access tokens are short-lived JWTs; refresh tokens are opaque, stored hashed, and rotated
+// on every use. Each refresh token belongs to a family (one per login) so that replaying an
+// already-rotated token revokes the whole family.
+var (
+	accessTokenTTL         = parseDurationEnv("ACCESS_TOKEN_TTL", "15m")
+	refreshTokenTTL        = parseDurationEnv("REFRESH_TOKEN_TTL", "168h")
+	sessionJanitorInterval = parseDurationEnv("SESSION_JANITOR_INTERVAL", "10m")
+)
+
+const refreshTokenPrefix = "dcert_"
+
+// issueSession signs an access token for the user and creates a refresh token in familyID
+// (a new family when empty).
+func issueSession(userID, username, role string, tokenVersion int, familyID string) (LoginResponse, error) {
+	jti, err := randomIDHex(16)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+	var mustChange bool
+	if err := db.QueryRow("SELECT must_change_password FROM users WHERE id = ?", userID).Scan(&mustChange); err != nil {
+		return LoginResponse{}, fmt.Errorf("load user flags: %w", err)
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":  username,
+		"role": role,
+		"iss":  "dce-fw-service",
+		"exp":  jwt.NewNumericDate(now.Add(accessTokenTTL)),
+		"iat":  jwt.NewNumericDate(now),
+		"jti":  jti,
+		"ver":  tokenVersion,
+		"mcp":  mustChange,
+	}
+	signed, err := signAccessToken(claims)
+	if err != nil {
+		return LoginResponse{}, fmt.Errorf("sign access token: %w", err)
+	}
+
+	refreshID, err := randomIDHex(12)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+	secret, err := randomIDHex(32)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+	if familyID == "" {
+		familyID = refreshID
+	}
+	refreshToken := refreshTokenPrefix + secret
+	perms, err := rolePermissions(context.Background(), role)
+	if err != nil {
+		return LoginResponse{}, fmt.Errorf("role permissions: %w", err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		refreshID, userID, familyID, hashAPIToken(refreshToken), now.Add(refreshTokenTTL)); err != nil {
+		return LoginResponse{}, fmt.Errorf("store refresh token: %w", err)
+	}
+	return LoginResponse{
+		Message:      "Authentication successful.",
+		Success:      true,
+		Role:         role,
+		Token:        signed,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		Permissions:  perms,
+		// Clients should route straight to the password change form
+		MustChangePassword: mustChange,
+	}, nil
+}
+
+// checkTokenRevocation enforces server-side revocation for an otherwise valid access token:
+// logged-out jti, deleted or disabled user, changed role, or bumped token_version.
+func checkTokenRevocation(claims jwt.MapClaims) error {
+	jti, _ := claims["jti"].(string)
+	sub, _ := claims["sub"].(string)
+	role, _ := claims["role"].(string)
+	ver, ok := claims["ver"].(float64)
+	if jti == "" || sub == "" || !ok {
+		return fmt.Errorf("token missing revocation claims")
+	}
+	var revoked int
+	if err := db.QueryRow("SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?", jti).Scan(&revoked); err != nil {
+		return fmt.Errorf("revocation lookup: %w", err)
+	}
+	if revoked > 0 {
+		return fmt.Errorf("token revoked")
+	}
+	var currentRole string
+	var currentVersion int
+	var disabled bool
+	if err := db.QueryRow("SELECT role, token_version, disabled FROM users WHERE username = ?", sub).Scan(&currentRole, &currentVersion, &disabled); err != nil {
+		return fmt.Errorf("user lookup: %w", err)
+	}
+	if disabled || currentRole != role || currentVersion != int(ver) {
+		return fmt.Errorf("token no longer valid for user")
+	}
+	return nil
+}
+
+// revokeUserSessions invalidates every access and refresh token of a user.
+func revokeUserSessions(userID string) error {
+	if _, err := db.Exec("UPDATE users SET token_version = token_version + 1 WHERE id = ?", userID); err != nil {
+		return err
+	}
+	_, err := db.Exec("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL", userID)
+	return err
+}
+
+// refreshHandler exchanges a refresh token for a new access/refresh token pair.
+// POST JSON {refreshToken}
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "Only POST requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		writeJSONError(w, "Missing refreshToken.", http.StatusBadRequest)
+		return
+	}
+
+	var id, userID, familyID, username, role string
+	var tokenVersion int
+	var expiresAt time.Time
+	var revokedAt sql.NullTime
+	var disabled bool
+	err := db.QueryRow(`
+		SELECT t.id, t.user_id, t.family_id, t.expires_at, t.revoked_at, u.username, u.role, u.token_version, u.disabled
+		FROM refresh_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ?`, hashAPIToken(req.RefreshToken)).
+		Scan(&id, &userID, &familyID, &expiresAt, &revokedAt, &username, &role, &tokenVersion, &disabled)
+	if err != nil {
+		writeJSONError(w, "Invalid refresh token.", http.StatusUnauthorized)
+		return
+	}
+	setAuditActor(r, username)
+	if revokedAt.Valid {
+		// A rotated token was presented again: treat the family as compromised.
+		log.Printf("Refresh token reuse detected for user %s (family %s); revoking family.", username, familyID)
+		db.Exec("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = ? AND revoked_at IS NULL", familyID)
+		writeJSONError(w, "Invalid refresh token.", http.StatusUnauthorized)
+		return
+	}
+	if time.Now().After(expiresAt) {
+		writeJSONError(w, "Refresh token expired.", http.StatusUnauthorized)
+		return
+	}
+	if disabled {
+		writeJSONError(w, "Account is disabled.", http.StatusUnauthorized)
+		return
+	}
+	// Rotate: only one concurrent caller can consume a given token.
+	res, err := db.Exec("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		writeJSONError(w, "Failed to rotate refresh token.", http.StatusInternalServerError)
+		return
+	}
+	if aff, _ := res.RowsAffected(); aff == 0 {
+		writeJSONError(w, "Invalid refresh token.", http.StatusUnauthorized)
+		return
+	}
+	resp, err := issueSession(userID, username, role, tokenVersion, familyID)
+	if err != nil {
+		log.Printf("Failed to issue session for %s: %v", username, err)
+		writeJSONError(w, "Failed to issue token.", http.StatusInternalServerError)
+		return
+	}
+	resp.Message = "Token refreshed."
+	json.NewEncoder(w).Encode(resp)
+}
+
+// logoutHandler revokes the presented access token (by jti) and the refresh token family.
+// POST JSON {refreshToken?, all?}; "all": true signs the user out of every session.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "Only POST requests are accepted", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+		All          bool   `json:"all"`
+	}
+	// Body is optional
+	json.NewDecoder(r.Body).Decode(&req)
+
+	var username string
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") && !strings.HasPrefix(auth[7:], apiTokenPrefix) {
+		if claims, err := parseAndValidateToken(auth[7:]); err == nil {
+			username, _ = claims["sub"].(string)
+			jti, _ := claims["jti"].(string)
+			exp, _ := claims.GetExpirationTime()
+			if jti != "" && exp != nil {
+				if _, err := db.Exec("INSERT IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)", jti, exp.Time); err != nil {
+					log.Printf("Failed to revoke jti %s: %v", jti, err)
+				}
+			}
+		}
+	}
+	setAuditActor(r, username)
+	if req.RefreshToken != "" {
+		var familyID string
+		if err := db.QueryRow("SELECT family_id FROM refresh_tokens WHERE token_hash = ?", hashAPIToken(req.RefreshToken)).Scan(&familyID); err == nil {
+			db.Exec("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = ? AND revoked_at IS NULL", familyID)
+		}
+	}
+	if req.All && username != "" {
+		var userID string
+		if err := db.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID); err == nil {
+			if err := revokeUserSessions(userID); err != nil {
+				log.Printf("Failed to revoke sessions of %s: %v", username, err)
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(ResponsePayload{Message: "Logged out.", Success: true})
+}
+
+// startSessionJanitor periodically drops expired revocation and refresh-token rows.
+func startSessionJanitor() {
+	ticker := time.NewTicker(sessionJanitorInterval)
+	go func() {
+		for range ticker.C {
+			if _, err := db.Exec("DELETE FROM revoked_tokens WHERE expires_at < CURRENT_TIMESTAMP"); err != nil {
+				log.Printf("Session janitor (revoked_tokens): %v", err)
+			}
+			if _, err := db.Exec("DELETE FROM refresh_tokens WHERE expires_at < CURRENT_TIMESTAMP"); err != nil {
+				log.Printf("Session janitor (refresh_tokens): %v", err)
+			}
+		}
+	}()
+}
@@ -1,23 +1,36 @@
 package main
 
+import (
+	"time"
+
+	"decode-dce-log-service/backend/nvlog"
+)
+
 // ------------------------------------------------------------------------------------------------	
 // ---------------- Struct Definitions ----------------
 // ------------------------------------------------------------------------------------------------
 
 // ResponsePayload defines the decoded result or general response sent to the frontend.
 type ResponsePayload struct {
-	DecodedLog string `json:"decodedLog"`
-	ElfFileName string `json:"elfFileName"`
-	Message    string `json:"message"`
-	Success    bool   `json:"success"`
+	DecodedLog  string `json:"decodedLog,omitempty"`
+	ElfFileName string `json:"elfFileName,omitempty"`
+	Message     string `json:"message"`
+	Success     bool   `json:"success"`
 }
 
 // LoginResponse defines the response for a successful login, including role information.
 type LoginResponse struct {
 	Message string `json:"message"`
 	Success bool   `json:"success"`
-	Role    string `json:"role"` // "admin" or "user"
+	Role    string `json:"role"` // see roles table
 	Token   string `json:"token"`
+	// Short-lived access token lifetime in seconds; renew with RefreshToken via /api/token/refresh.
+	RefreshToken string `json:"refreshToken,omitempty"`
+	ExpiresIn    int    `json:"expiresIn,omitempty"`
+	// Permissions granted by Role at issue time (informational; enforced server-side per request).
+	Permissions []string `json:"permissions,omitempty"`
+	// MustChangePassword: every endpoint except POST /api/me/password is refused until changed.
+	MustChangePassword bool `json:"mustChangePassword,omitempty"`
 }
 
 // NewAdminUser defines the request body when an admin adds a user.
@@ -31,8 +44,21 @@ type NewAdminUser struct {
 type User struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
-	Password string `json:"password"` // Note: plaintext for simplicity (consider hashing in production)
+	Password string `json:"password"` // Stored as a prefixed hash (see passwords.go); never returned to clients
 	Role     string `json:"role"`
+	// Listing-only fields
+	AuthSource         string `json:"authSource"`
+	Disabled           bool   `json:"disabled"`
+	MustChangePassword bool   `json:"mustChangePassword"`
+}
+
+// UpdateAdminUser is the PATCH/PUT /api/admin/users body; nil fields are left unchanged.
+type UpdateAdminUser struct {
+	ID       string  `json:"id"`
+	Username *string `json:"username,omitempty"`
+	Role     *string `json:"role,omitempty"`
+	Password *string `json:"password,omitempty"` // set by the admin; the user must change it at next login
+	Disabled *bool   `json:"disabled,omitempty"`
 }
 
 // Pushtag mapping request
@@ -47,4 +73,143 @@ type ElfRecord struct {
 	ElfFileName string `json:"elfFileName"`
 }
 
+// ELFCandidate is a stored ELF matching an ambiguous decode selection (409 elf_ambiguous).
+type ELFCandidate struct {
+	BuildID     string `json:"buildId"`
+	ElfFileName string `json:"elfFileName"`
+	Pushtag     string `json:"pushtag,omitempty"`
+}
+
+// APIToken describes a personal API token (the secret itself is only returned once, on creation).
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"` // "" (full), "decode" or "elf-write"
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+// NewAPITokenRequest defines the request body when a user creates an API token.
+type NewAPITokenRequest struct {
+	Name          string `json:"name"`
+	Scope         string `json:"scope"`
+	ExpiresInDays int    `json:"expiresInDays"` // 0 = never expires
+}
+
+// InspectELF reports whether build_elves holds the ELF for an inspected log.
+type InspectELF struct {
+	Found       bool   `json:"found"`
+	ElfFileName string `json:"elfFileName,omitempty"`
+	Pushtag     string `json:"pushtag,omitempty"`
+}
+
+// InspectResponse is returned by POST /api/inspect.
+type InspectResponse struct {
+	Success   bool          `json:"success"`
+	FileName  string        `json:"fileName"`
+	Header    *nvlog.Header `json:"header"`
+	ELF       InspectELF    `json:"elf"`
+	Decodable bool          `json:"decodable"`        // /api/decode would accept this log, with Format if set
+	Format    string        `json:"format,omitempty"` // /api/decode format the log needs, e.g. NVLOG for full dumps
+	Message   string        `json:"message"`
+}
+
+// BatchFileResult is one entry of the manifest returned by POST /api/decode/batch.
+type BatchFileResult struct {
+	Name        string `json:"name"`   // upload file name or path inside the uploaded archive
+	Status      string `json:"status"` // decoded, elf_missing, invalid_log, decoder_error or error
+	BuildID     string `json:"buildId,omitempty"`
+	ElfFileName string `json:"elfFileName,omitempty"`
+	Output      string `json:"output,omitempty"`    // path of the decoded log inside the response archive
+	ErrorCode   string `json:"errorCode,omitempty"` // decodeError code, e.g. decoder_timeout
+	Error       string `json:"error,omitempty"`
+}
 
+// BatchManifest is manifest.json of a batch decode response.
+type BatchManifest struct {
+	Total   int               `json:"total"`
+	Decoded int               `json:"decoded"`
+	Failed  int               `json:"failed"`
+	Files   []BatchFileResult `json:"files"`
+}
+
+// DecoderStatus reports how nvlog_decoder exited for a decode.
+type DecoderStatus struct {
+	ExitCode        int    `json:"exitCode"` // -1 if killed by a signal (e.g. a limit) or not started
+	Stderr          string `json:"stderr"`   // last lines of the decoder's console output
+	StderrTruncated bool   `json:"stderrTruncated,omitempty"`
+}
+
+// DecodeTimings are the phases of a decode in milliseconds.
+type DecodeTimings struct {
+	QueueWaitMs int64 `json:"queueWaitMs"`
+	DecoderMs   int64 `json:"decoderMs"`
+	TotalMs     int64 `json:"totalMs"`
+}
+
+// DecodeResponse is returned by POST /api/decode for `Accept: application/json`, on success
+// and failure. DecodedLog holds the output when it fits the inline limit; otherwise ResultURL
+// points to it.
+type DecodeResponse struct {
+	ResponsePayload
+	BuildID       string         `json:"buildId,omitempty"`
+	Pushtag       string         `json:"pushtag,omitempty"`
+	ErrorCode     string         `json:"errorCode,omitempty"`
+	Warnings      []string       `json:"warnings,omitempty"`
+	Candidates    []ELFCandidate `json:"candidates,omitempty"`
+	Decoder       *DecoderStatus `json:"decoder,omitempty"` // absent if the decoder did not run
+	QueuePosition int            `json:"queuePosition,omitempty"`
+	Timings       DecodeTimings  `json:"timings"`
+	LineCount     int            `json:"lineCount"`
+	OutputBytes   int64          `json:"outputBytes"`
+	ResultURL     string         `json:"resultUrl,omitempty"`
+}
+
+// LogRecord is one line of a decoded log (decoded_records.go), as returned by the decode API
+// for output=ndjson|csv.
+type LogRecord struct {
+	Line       int    `json:"line"`                // 1-based line in dce-decoded.log
+	Timestamp  string `json:"timestamp,omitempty"` // wall-clock time printed by the decoder, 2006-01-02T15:04:05
+	Ticks      string `json:"ticks,omitempty"`     // timer value after "T:"
+	Severity   string `json:"severity,omitempty"`  // error, warning, info or debug
+	Module     string `json:"module,omitempty"`    // log source (e.g. DCE) or nvlog module
+	Tag        string `json:"tag,omitempty"`       // LibOS task prefix or nvlog sub-module
+	File       string `json:"file,omitempty"`
+	SourceLine int    `json:"sourceLine,omitempty"`
+	Message    string `json:"message"`
+}
+
+// SymbolizeRequest is the body of POST /api/symbolize; either BuildID or ElfFileName selects
+// the ELF.
+type SymbolizeRequest struct {
+	BuildID     string   `json:"buildId"`
+	ElfFileName string   `json:"elfFileName"`
+	PCs         []string `json:"pcs"`           // hex program counters, e.g. "0x1a2b"
+	Tag         string   `json:"tag,omitempty"` // LibOS task tag or logging section; all tasks if empty
+}
+
+// PCLocation is one source location of a program counter.
+type PCLocation struct {
+	Task     string `json:"task,omitempty"`
+	Function string `json:"function,omitempty"` // empty if the PC is outside any known function
+	Offset   int64  `json:"offset"`             // bytes from the start of Function
+	File     string `json:"file,omitempty"`
+	Line     int64  `json:"line"`
+}
+
+// PCSymbol is the result for one requested program counter.
+type PCSymbol struct {
+	PC        string       `json:"pc"`
+	Resolved  bool         `json:"resolved"`
+	Locations []PCLocation `json:"locations"` // one per LibOS task that maps the PC
+}
+
+// SymbolizeResponse is returned by POST /api/symbolize.
+type SymbolizeResponse struct {
+	Success     bool       `json:"success"`
+	BuildID     string     `json:"buildId"`
+	ElfFileName string     `json:"elfFileName"`
+	Symbols     []PCSymbol `json:"symbols"`
+}
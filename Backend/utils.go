@@ -4,8 +4,10 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 )
 // ------------------------------------------------------------------------------------------------	
 // ---------------- Utility Functions ----------------
@@ -33,9 +35,29 @@ func getenv(key, fallback string) string {
 	return fallback
 }
 
+// getJWTSecret returns the HMAC secret for the default keyring key and the OIDC state cookie.
+// initKeyring refuses the development default outside APP_ENV=dev.
 func getJWTSecret() []byte {
-	// For production, override via env JWT_SECRET
-	return []byte(getenv("JWT_SECRET", "dev-secret"))
+	return []byte(getenv("JWT_SECRET", defaultJWTSecret))
 }
 
+// trustProxyHeaders controls whether X-Real-IP / X-Forwarded-For (set by the Nginx frontend)
+// are trusted. Disable it when the backend is reachable without going through the proxy.
+var trustProxyHeaders = getenv("TRUST_PROXY_HEADERS", "true") == "true"
 
+// clientIP returns the caller's IP address for throttling and logging.
+func clientIP(r *http.Request) string {
+	if trustProxyHeaders {
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			return strings.TrimSpace(strings.Split(xff, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
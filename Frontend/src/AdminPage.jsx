@@ -1,5 +1,8 @@
 import React, { useState, useCallback, useEffect, useRef } from 'react';
-import { StatusMessage, API_ADMIN_USERS_URL, API_ADMIN_ELVES_URL, API_ADMIN_ELVES_UPLOAD_URL, API_ADMIN_ELVES_BY_URL_STREAM_URL, API_ADMIN_ELVES_BY_URL_START_URL, API_ADMIN_ELVES_BY_URL_STATUS_URL, API_ADMIN_ELVES_BY_URL_CANCEL_URL, API_ADMIN_ELVES_BY_URL_CLEAR_URL, authHeader } from './Constants.jsx'; 
+import { StatusMessage, API_ADMIN_USERS_URL, API_ADMIN_USERS_RESET_PASSWORD_URL, API_ADMIN_ELVES_URL, API_ADMIN_ELVES_UPLOAD_URL, API_ADMIN_ELVES_BY_URL_STREAM_URL, API_ADMIN_ELVES_BY_URL_START_URL, API_ADMIN_ELVES_BY_URL_STATUS_URL, API_ADMIN_ELVES_BY_URL_CANCEL_URL, API_ADMIN_ELVES_BY_URL_CLEAR_URL, API_ADMIN_DECODER_POOL_URL, authHeader } from './Constants.jsx'; 
+
+// Roles seeded by the backend; custom roles show up in the table as they are assigned
+const ROLE_OPTIONS = ['user', 'decoder', 'elf-curator', 'user-admin', 'auditor', 'admin'];
 
 const AdminPage = ({ userId, token }) => {
     const [newUsername, setNewUsername] = useState('');
@@ -21,6 +24,8 @@ const AdminPage = ({ userId, token }) => {
     const fileInputRef = useRef(null);
     const [byUrlSteps, setByUrlSteps] = useState([]);
     const streamAbortRef = useRef(null);
+    // Decoder pool utilization; stays null without the stats:read permission
+    const [decoderPool, setDecoderPool] = useState(null);
     const BY_URL_STATE_KEY = 'dce_by_url_state';
 
     const fetchUsers = useCallback(async () => {
@@ -91,6 +96,45 @@ const AdminPage = ({ userId, token }) => {
         }
     }, [fetchUsers, token]);
 
+    // PATCH a single user (role change, disable/enable)
+    const handleUpdateUser = useCallback(async (id, username, changes) => {
+        try {
+            const response = await fetch(API_ADMIN_USERS_URL, {
+                method: 'PATCH',
+                headers: { 'Content-Type': 'application/json', ...authHeader(token) },
+                body: JSON.stringify({ id, ...changes }),
+            });
+            const result = await response.json();
+            if (!response.ok || !result.success) {
+                throw new Error(result.message || `Backend error: ${response.status}`);
+            }
+            setStatus({ message: result.message || `User '${username}' updated.`, type: 'success' });
+            fetchUsers();
+        } catch (err) {
+            setStatus({ message: `Failed to update user: ${err.message}`, type: 'error' });
+        }
+    }, [fetchUsers, token]);
+
+    const handleResetPassword = useCallback(async (id, username) => {
+        if (!id) return;
+        const ok = window.confirm(`Reset the password of '${username}'? All of their sessions will be signed out.`);
+        if (!ok) return;
+        try {
+            const response = await fetch(API_ADMIN_USERS_RESET_PASSWORD_URL, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', ...authHeader(token) },
+                body: JSON.stringify({ id }),
+            });
+            const result = await response.json();
+            if (!response.ok || !result.success) {
+                throw new Error(result.message || `Backend error: ${response.status}`);
+            }
+            setStatus({ message: `${result.message}\nTemporary password: ${result.temporaryPassword}`, type: 'success' });
+        } catch (err) {
+            setStatus({ message: `Failed to reset password: ${err.message}`, type: 'error' });
+        }
+    }, [token]);
+
     const fetchElves = useCallback(async () => {
         try {
             const response = await fetch(API_ADMIN_ELVES_URL, { headers: { ...authHeader(token) } });
@@ -105,6 +149,25 @@ const AdminPage = ({ userId, token }) => {
         }
     }, [token]);
 
+    const fetchDecoderPool = useCallback(async () => {
+        try {
+            const response = await fetch(API_ADMIN_DECODER_POOL_URL, { headers: { ...authHeader(token) } });
+            if (!response.ok) {
+                setDecoderPool(null);
+                return;
+            }
+            setDecoderPool(await response.json());
+        } catch (err) {
+            setDecoderPool(null);
+        }
+    }, [token]);
+
+    useEffect(() => {
+        fetchDecoderPool();
+        const timer = setInterval(fetchDecoderPool, 5000);
+        return () => clearInterval(timer);
+    }, [fetchDecoderPool]);
+
     const handleFetchElfByURL = useCallback(async (e) => {
         e.preventDefault();
         if (!pushtag || !pushtagUrl) {
@@ -471,8 +534,7 @@ const AdminPage = ({ userId, token }) => {
                     <div className="flex items-center gap-3">
                         <label className="text-sm text-gray-700">Role</label>
                         <select value={newRole} onChange={e => setNewRole(e.target.value)} className="px-3 py-2 border rounded-xl focus:outline-none">
-                            <option value="user">user</option>
-                            <option value="admin">admin</option>
+                            {ROLE_OPTIONS.map(r => <option key={r} value={r}>{r}</option>)}
                         </select>
                     </div>
                     <button type="submit" disabled={isAddUserLoading}
@@ -502,10 +564,25 @@ const AdminPage = ({ userId, token }) => {
                             ) : users.length > 0 ? (
                                 users.map((user) => (
                                     <tr key={user.id}>
-                                        <td className="px-6 py-4 whitespace-nowrap text-sm font-medium text-gray-900">{user.username}</td>
-                                        <td className="px-6 py-4 whitespace-nowrap text-sm text-gray-500">{user.role}</td>
+                                        <td className="px-6 py-4 whitespace-nowrap text-sm font-medium text-gray-900">
+                                            {user.username}
+                                            {user.disabled && <span className="ml-2 px-2 py-0.5 text-xs rounded bg-gray-200 text-gray-600">disabled</span>}
+                                        </td>
+                                        <td className="px-6 py-4 whitespace-nowrap text-sm text-gray-500">
+                                            <select value={user.role} onChange={e => handleUpdateUser(user.id, user.username, { role: e.target.value })} className="px-2 py-1 border rounded-lg text-sm">
+                                                {[...new Set([...ROLE_OPTIONS, user.role])].map(r => <option key={r} value={r}>{r}</option>)}
+                                            </select>
+                                        </td>
                                         <td className="px-6 py-4 text-xs font-mono text-gray-400 truncate max-w-[100px]">{user.id}</td>
-                                        <td className="px-6 py-4 text-right">
+                                        <td className="px-6 py-4 text-right space-x-2 whitespace-nowrap">
+                                            <button onClick={() => handleUpdateUser(user.id, user.username, { disabled: !user.disabled })}
+                                                className="px-3 py-1 text-xs rounded-lg bg-gray-100 hover:bg-yellow-100 text-yellow-800">
+                                                {user.disabled ? 'Enable' : 'Disable'}
+                                            </button>
+                                            <button onClick={() => handleResetPassword(user.id, user.username)}
+                                                className="px-3 py-1 text-xs rounded-lg bg-gray-100 hover:bg-indigo-100 text-indigo-700">
+                                                Reset password
+                                            </button>
                                             <button onClick={() => handleDeleteUser(user.id, user.username)}
                                                 className="px-3 py-1 text-xs rounded-lg bg-gray-100 hover:bg-red-100 text-red-700">
                                                 Delete
@@ -525,6 +602,37 @@ const AdminPage = ({ userId, token }) => {
                 </p>
             </div>
 
+            {/* Decoder Pool Card (only with stats:read) */}
+            {decoderPool && (
+                <div className="w-full bg-white/80 backdrop-blur p-8 rounded-2xl shadow-xl border border-white/60">
+                    <div className="flex items-end justify-between gap-4 mb-4">
+                        <h3 className="text-2xl font-extrabold text-indigo-700 tracking-tight">Decoder Pool</h3>
+                        <span className="text-sm text-gray-600">
+                            {decoderPool.busy}/{decoderPool.workers} busy · queue {decoderPool.queued}/{decoderPool.queueSize} · {decoderPool.rejected} rejected · avg {(decoderPool.avgTaskMs / 1000).toFixed(1)}s
+                        </span>
+                    </div>
+                    <div className="space-y-2">
+                        {(decoderPool.workerStats || []).map((w) => (
+                            <div key={w.index} className="grid grid-cols-12 gap-3 items-center text-sm">
+                                <span className="col-span-2 font-mono text-gray-700">worker {w.index}</span>
+                                <div className="col-span-6 h-3 bg-gray-100 rounded-full overflow-hidden">
+                                    <div className="h-full bg-gradient-to-r from-indigo-500 to-blue-500" style={{ width: `${Math.min(100, w.utilization * 100).toFixed(1)}%` }} />
+                                </div>
+                                <span className="col-span-1 text-right text-gray-700">{(w.utilization * 100).toFixed(0)}%</span>
+                                <span className={`col-span-3 text-xs ${w.busy ? 'text-indigo-700' : 'text-gray-400'}`}>
+                                    {w.busy ? `busy ${(w.busyForMs / 1000).toFixed(0)}s${w.owner ? ` (${w.owner})` : ''}` : 'idle'} · {w.tasks} done
+                                </span>
+                            </div>
+                        ))}
+                    </div>
+                    {decoderPool.sandbox && (
+                        <p className={`mt-4 text-xs ${decoderPool.sandbox.startsWith('active') ? 'text-green-700' : 'text-amber-700'}`}>
+                            Decoder sandbox: {decoderPool.sandbox}
+                        </p>
+                    )}
+                </div>
+            )}
+
             {/* ELF Library Management Card (wider) */}
             <div className="w-full bg-white/80 backdrop-blur p-8 rounded-2xl shadow-xl border border-white/60">
                 <div className="flex items-end justify-between gap-4 mb-6">
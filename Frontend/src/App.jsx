@@ -2,39 +2,154 @@ import React, { useState, useCallback, useEffect } from 'react';
 import LoginPage from './LoginPage.jsx';
 import LogDecoder from './LogDecoder.jsx';
 import AdminPage from './AdminPage.jsx';
-import { StatusMessage } from './Constants.jsx'; 
+import ChangePasswordPage from './ChangePasswordPage.jsx';
+import { StatusMessage, API_REFRESH_URL, API_LOGOUT_URL, authHeader } from './Constants.jsx'; 
+
+// Tabs share one session in localStorage. Presenting a rotated refresh token again revokes the
+// whole token family, so only one tab refreshes at a time and the others adopt its result.
+const readStoredAuth = () => {
+    try { return JSON.parse(localStorage.getItem('dce-auth') || 'null'); } catch { return null; }
+};
+const withRefreshLock = (fn) => (navigator.locks ? navigator.locks.request('dce-auth-refresh', fn) : fn());
 
 const App = () => {
     const [isLoggedIn, setIsLoggedIn] = useState(false);
     const [currentView, setCurrentView] = useState('decoder'); 
     const [userId, setUserId] = useState("mock-user-dce-001"); 
     const [userRole, setUserRole] = useState(null);
+    const [permissions, setPermissions] = useState([]);
+    const [mustChangePassword, setMustChangePassword] = useState(false);
     const [token, setToken] = useState('');
+    const [refreshToken, setRefreshToken] = useState('');
+    const [tokenExpiresAt, setTokenExpiresAt] = useState(0);
     const [authStatus, setAuthStatus] = useState({ message: 'Welcome to DCE-FW Log Service.', type: 'info' });
 
-    const handleLoginSuccess = useCallback((role, jwtToken) => { 
+    const handleLoginSuccess = useCallback((role, jwtToken, jwtRefreshToken, expiresIn, perms, mustChange) => { 
+        const expiresAt = expiresIn ? Date.now() + expiresIn * 1000 : 0;
         setIsLoggedIn(true);
         setUserRole(role);
+        setPermissions(perms || []);
+        setMustChangePassword(!!mustChange);
         setToken(jwtToken || '');
+        setRefreshToken(jwtRefreshToken || '');
+        setTokenExpiresAt(expiresAt);
         setAuthStatus({ message: 'Login successful. Navigating to decoder.', type: 'success' });
         setCurrentView('decoder');
         try {
-            const next = { isLoggedIn: true, userRole: role, currentView: 'decoder', token: jwtToken || '' };
+            const next = { isLoggedIn: true, userRole: role, permissions: perms || [], mustChangePassword: !!mustChange, currentView: 'decoder', token: jwtToken || '', refreshToken: jwtRefreshToken || '', tokenExpiresAt: expiresAt };
             localStorage.setItem('dce-auth', JSON.stringify(next));
         } catch {}
     }, []);
     
-    const handleLogout = useCallback(() => {
+    const clearSession = useCallback((message, type) => {
         setIsLoggedIn(false);
         setUserRole(null); 
+        setPermissions([]);
+        setMustChangePassword(false);
         setToken('');
+        setRefreshToken('');
+        setTokenExpiresAt(0);
         setCurrentView('decoder');
-        setAuthStatus({ message: 'Logged out successfully.', type: 'info' });
+        setAuthStatus({ message, type });
         try { localStorage.removeItem('dce-auth'); } catch {}
-    }, []); 
+    }, []);
+
+    // Take over a session another tab stored (login or token refresh)
+    const adoptSession = useCallback((saved) => {
+        setIsLoggedIn(true);
+        setUserRole(saved.userRole || null);
+        setPermissions(saved.permissions || []);
+        setMustChangePassword(!!saved.mustChangePassword);
+        setToken(saved.token || '');
+        setRefreshToken(saved.refreshToken || '');
+        setTokenExpiresAt(saved.tokenExpiresAt || 0);
+    }, []);
+
+    const handleLogout = useCallback(() => {
+        // Best-effort server-side revocation; local state is cleared regardless
+        fetch(API_LOGOUT_URL, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json', ...authHeader(token) },
+            body: JSON.stringify({ refreshToken }),
+        }).catch(() => {});
+        clearSession('Logged out successfully.', 'info');
+    }, [token, refreshToken, clearSession]); 
+
+    // Renew the short-lived access token shortly before it expires
+    useEffect(() => {
+        if (!isLoggedIn || !refreshToken) return;
+        const delay = Math.max((tokenExpiresAt || 0) - Date.now() - 60 * 1000, 0);
+        const rotatedElsewhere = () => {
+            const saved = readStoredAuth();
+            return saved?.isLoggedIn && saved.refreshToken && saved.refreshToken !== refreshToken ? saved : null;
+        };
+        const timer = setTimeout(() => withRefreshLock(async () => {
+            // Another tab may have refreshed while this one waited
+            const saved = rotatedElsewhere();
+            if (saved) {
+                adoptSession(saved);
+                return;
+            }
+            try {
+                const res = await fetch(API_REFRESH_URL, {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ refreshToken }),
+                });
+                const result = await res.json();
+                if (!res.ok || !result.success) throw new Error(result.message || 'refresh failed');
+                const next = {
+                    ...readStoredAuth(),
+                    isLoggedIn: true,
+                    userRole: result.role || null,
+                    permissions: result.permissions || [],
+                    mustChangePassword: !!result.mustChangePassword,
+                    token: result.token || '',
+                    refreshToken: result.refreshToken || '',
+                    tokenExpiresAt: result.expiresIn ? Date.now() + result.expiresIn * 1000 : 0,
+                };
+                // Store before releasing the lock so the next tab sees the rotated token
+                try { localStorage.setItem('dce-auth', JSON.stringify(next)); } catch {}
+                adoptSession(next);
+            } catch {
+                const saved = rotatedElsewhere();
+                if (saved) {
+                    adoptSession(saved);
+                    return;
+                }
+                clearSession('Session expired. Please log in again.', 'warning');
+            }
+        }), delay);
+        return () => clearTimeout(timer);
+    }, [isLoggedIn, refreshToken, tokenExpiresAt, adoptSession, clearSession]);
+
+    // Follow logins, refreshes and logouts of other tabs
+    useEffect(() => {
+        const onStorage = (e) => {
+            if (e.key !== 'dce-auth') return;
+            const saved = readStoredAuth();
+            if (saved?.isLoggedIn) {
+                adoptSession(saved);
+            } else if (e.oldValue) {
+                clearSession('Logged out in another tab.', 'info');
+            }
+        };
+        window.addEventListener('storage', onStorage);
+        return () => window.removeEventListener('storage', onStorage);
+    }, [adoptSession, clearSession]);
 
     // Restore login state on first mount
     useEffect(() => {
+        // SSO callback hands the session over in the URL fragment
+        const hash = new URLSearchParams(window.location.hash.slice(1));
+        if (hash.get('token') || hash.get('oidcError')) {
+            window.history.replaceState(null, '', window.location.pathname + window.location.search);
+            if (hash.get('token')) {
+                handleLoginSuccess(hash.get('role') || 'user', hash.get('token'), hash.get('refreshToken') || '', Number(hash.get('expiresIn')) || 0, (hash.get('permissions') || '').split(',').filter(Boolean));
+                return;
+            }
+            setAuthStatus({ message: `SSO login failed: ${hash.get('oidcError')}`, type: 'error' });
+        }
         try {
             const raw = localStorage.getItem('dce-auth');
             if (raw) {
@@ -42,7 +157,11 @@ const App = () => {
                 if (saved?.isLoggedIn) {
                     setIsLoggedIn(true);
                     setUserRole(saved.userRole || null);
+                    setPermissions(saved.permissions || []);
+                    setMustChangePassword(!!saved.mustChangePassword);
                     setToken(saved.token || '');
+                    setRefreshToken(saved.refreshToken || '');
+                    setTokenExpiresAt(saved.tokenExpiresAt || 0);
                     setCurrentView(saved.currentView || 'decoder');
                     setAuthStatus({ message: 'Session restored.', type: 'success' });
                 }
@@ -56,22 +175,35 @@ const App = () => {
         try {
             const raw = localStorage.getItem('dce-auth');
             const saved = raw ? JSON.parse(raw) : {};
-            const next = { ...saved, isLoggedIn, userRole, currentView, token };
+            const next = { ...saved, isLoggedIn, userRole, permissions, mustChangePassword, currentView, token, refreshToken, tokenExpiresAt };
             if (isLoggedIn) {
                 localStorage.setItem('dce-auth', JSON.stringify(next));
             }
         } catch {}
-    }, [isLoggedIn, userRole, currentView, token]);
+    }, [isLoggedIn, userRole, permissions, mustChangePassword, currentView, token, refreshToken, tokenExpiresAt]);
+
+    // Any permission beyond decoding unlocks (parts of) the admin page
+    const canAdmin = userRole === 'admin' || permissions.some(p => p !== 'decode');
+
+    // A successful password change returns a fresh session (all older ones are revoked)
+    const handlePasswordChanged = useCallback((result) => {
+        handleLoginSuccess(result.role, result.token, result.refreshToken || '', result.expiresIn || 0, result.permissions || [], result.mustChangePassword);
+    }, [handleLoginSuccess]);
 
     const renderContent = () => {
         if (!isLoggedIn) {
             return <LoginPage onLoginSuccess={handleLoginSuccess} />;
+        }
+        if (mustChangePassword) {
+            return <ChangePasswordPage token={token} forced onPasswordChanged={handlePasswordChanged} />;
         }
                 switch (currentView) {
             case 'decoder':
                         return <LogDecoder userId={userId} token={token} />;
             case 'admin':
                         return <AdminPage userId={userId} token={token} />; 
+            case 'password':
+                        return <ChangePasswordPage token={token} onPasswordChanged={handlePasswordChanged} />;
             default:
                         return <LogDecoder userId={userId} token={token} />;
         }
@@ -84,7 +216,7 @@ const App = () => {
                 
                 {isLoggedIn && (
                     <div className="flex items-center space-x-3">
-                        <nav className="flex space-x-2">
+                        {!mustChangePassword && <nav className="flex space-x-2">
                             <button 
                                 onClick={() => setCurrentView('decoder')}
                                 className={`px-3 py-1 rounded-xl text-sm font-medium transition duration-150 ${currentView === 'decoder' ? 'bg-indigo-600 text-white shadow' : 'text-gray-700 hover:bg-gray-100'}`}
@@ -92,7 +224,7 @@ const App = () => {
                                 Log Decoder
                             </button>
                             
-                            {userRole === 'admin' && ( 
+                            {canAdmin && ( 
                                 <button 
                                     onClick={() => setCurrentView('admin')}
                                     className={`px-3 py-1 rounded-xl text-sm font-medium transition duration-150 ${currentView === 'admin' ? 'bg-red-600 text-white shadow' : 'text-gray-700 hover:bg-gray-100'}`}
@@ -100,7 +232,13 @@ const App = () => {
                                     Admin
                                 </button>
                             )}
-                        </nav>
+                            <button 
+                                onClick={() => setCurrentView('password')}
+                                className={`px-3 py-1 rounded-xl text-sm font-medium transition duration-150 ${currentView === 'password' ? 'bg-indigo-600 text-white shadow' : 'text-gray-700 hover:bg-gray-100'}`}
+                            >
+                                Password
+                            </button>
+                        </nav>}
                         <button 
                             onClick={handleLogout}
                             className="px-3 py-1 rounded-xl text-sm font-medium text-white bg-gray-700 hover:bg-gray-800 transition duration-150"
@@ -112,7 +250,7 @@ const App = () => {
             </header>
             
             <main className="max-w-5xl mx-auto px-4 md:px-6 pb-12">
-                {isLoggedIn && <StatusMessage message={authStatus.message} type={authStatus.type} />}
+                {(isLoggedIn || authStatus.type === 'error' || authStatus.type === 'warning') && <StatusMessage message={authStatus.message} type={authStatus.type} />}
                 {renderContent()}
             </main>
         </div>
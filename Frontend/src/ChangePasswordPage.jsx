@@ -0,0 +1,65 @@
+import React, { useState, useCallback } from 'react';
+import { API_ME_PASSWORD_URL, StatusMessage, authHeader } from './Constants.jsx';
+
+// Shown instead of the app while the account must change its password (and reachable from the header otherwise)
+const ChangePasswordPage = ({ token, forced, onPasswordChanged }) => {
+    const [currentPassword, setCurrentPassword] = useState('');
+    const [newPassword, setNewPassword] = useState('');
+    const [confirmPassword, setConfirmPassword] = useState('');
+    const [status, setStatus] = useState(forced
+        ? { message: 'You must change your password before continuing.', type: 'warning' }
+        : { message: '', type: 'info' });
+    const [isLoading, setIsLoading] = useState(false);
+
+    const handleSubmit = useCallback(async (e) => {
+        e.preventDefault();
+        if (newPassword !== confirmPassword) {
+            setStatus({ message: 'New passwords do not match.', type: 'error' });
+            return;
+        }
+        setIsLoading(true);
+        try {
+            const response = await fetch(API_ME_PASSWORD_URL, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', ...authHeader(token) },
+                body: JSON.stringify({ currentPassword, newPassword }),
+            });
+            const result = await response.json();
+            if (!response.ok || !result.success) {
+                throw new Error(result.message || `Backend error: ${response.status}`);
+            }
+            setCurrentPassword('');
+            setNewPassword('');
+            setConfirmPassword('');
+            setStatus({ message: 'Password changed.', type: 'success' });
+            onPasswordChanged(result);
+        } catch (err) {
+            setStatus({ message: `Failed to change password: ${err.message}`, type: 'error' });
+        } finally {
+            setIsLoading(false);
+        }
+    }, [currentPassword, newPassword, confirmPassword, token, onPasswordChanged]);
+
+    return (
+        <div className="min-h-[50vh] grid place-items-center px-4">
+            <div className="w-full max-w-sm bg-white/80 backdrop-blur p-8 rounded-2xl shadow-xl border border-indigo-100">
+                <h2 className="text-2xl font-extrabold text-indigo-800 text-center mb-6 tracking-tight">Change Password</h2>
+                <form onSubmit={handleSubmit} className="space-y-4">
+                    <input type="password" placeholder="Current password" required value={currentPassword} onChange={e => setCurrentPassword(e.target.value)}
+                        className="w-full px-4 py-2 border border-gray-300 rounded-xl focus:ring-2 focus:ring-indigo-500 focus:border-indigo-500" />
+                    <input type="password" placeholder="New password" required value={newPassword} onChange={e => setNewPassword(e.target.value)}
+                        className="w-full px-4 py-2 border border-gray-300 rounded-xl focus:ring-2 focus:ring-indigo-500 focus:border-indigo-500" />
+                    <input type="password" placeholder="Confirm new password" required value={confirmPassword} onChange={e => setConfirmPassword(e.target.value)}
+                        className="w-full px-4 py-2 border border-gray-300 rounded-xl focus:ring-2 focus:ring-indigo-500 focus:border-indigo-500" />
+                    <button type="submit" disabled={isLoading}
+                        className="w-full py-2.5 px-4 rounded-xl shadow-md text-base font-semibold text-white bg-gradient-to-r from-indigo-600 to-blue-600 hover:from-indigo-700 hover:to-blue-700 transition duration-150 disabled:opacity-50">
+                        {isLoading ? 'Saving...' : 'Change Password'}
+                    </button>
+                </form>
+                <StatusMessage message={status.message} type={status.type} />
+            </div>
+        </div>
+    );
+};
+
+export default ChangePasswordPage;
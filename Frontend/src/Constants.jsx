@@ -1,6 +1,11 @@
 export const API_DECODE_URL = '/api/decode'; 
 export const API_LOGIN_URL = '/api/login'; 
+export const API_REFRESH_URL = '/api/token/refresh';
+export const API_LOGOUT_URL = '/api/logout';
+export const API_OIDC_CONFIG_URL = '/api/auth/oidc/config';
+export const API_ME_PASSWORD_URL = '/api/me/password';
 export const API_ADMIN_USERS_URL = '/api/admin/users'; 
+export const API_ADMIN_USERS_RESET_PASSWORD_URL = '/api/admin/users/reset-password';
 // --- Admin ELF APIs ---
 export const API_ADMIN_ELVES_URL = '/api/admin/elves';
 export const API_ADMIN_ELVES_UPLOAD_URL = '/api/admin/elves/upload';
@@ -9,6 +14,8 @@ export const API_ADMIN_ELVES_BY_URL_START_URL = '/api/admin/elves/by-url/start';
 export const API_ADMIN_ELVES_BY_URL_STATUS_URL = '/api/admin/elves/by-url/status';
 export const API_ADMIN_ELVES_BY_URL_CANCEL_URL = '/api/admin/elves/by-url/cancel';
 export const API_ADMIN_ELVES_BY_URL_CLEAR_URL = '/api/admin/elves/by-url/clear';
+// --- Admin dashboard ---
+export const API_ADMIN_DECODER_POOL_URL = '/api/admin/decoder-pool';
 // Helper: build Authorization header
 export const authHeader = (token) => token ? { 'Authorization': `Bearer ${token}` } : {};
 
@@ -1,8 +1,33 @@
 import React, { useState, useCallback } from 'react';
 import { API_DECODE_URL, StatusMessage, authHeader } from './Constants.jsx';
 
+// nvlog_decoder formats accepted by /api/decode (field `format`)
+const DECODE_FORMATS = [
+  { value: 'DCE', label: 'DCE (LIBOS buffer)' },
+  { value: 'GSP_LIBOS2', label: 'GSP LIBOS2 (LIBOS buffer)' },
+  { value: 'GSP_LIBOS3', label: 'GSP LIBOS3 (LIBOS buffer)' },
+  { value: 'PMU', label: 'PMU (LIBOS buffer)' },
+  { value: 'NVLOG', label: 'Full nvlog dump' },
+];
+
+// Download formats of /api/decode (field `output`)
+const OUTPUT_FORMATS = [
+  { value: 'text', label: 'Text (dce-decoded.log)', fileName: 'dce-decoded.log' },
+  { value: 'ndjson', label: 'NDJSON records', fileName: 'dce-decoded.ndjson' },
+  { value: 'csv', label: 'CSV records', fileName: 'dce-decoded.csv' },
+];
+
 const LogDecoder = ({ userId, token }) => {
   const [file, setFile] = useState(null);
+  const [format, setFormat] = useState('DCE');
+  const [tag, setTag] = useState('');
+  const [bufferIndex, setBufferIndex] = useState('');
+  const [reverse, setReverse] = useState(false);
+  const [output, setOutput] = useState('text');
+  const [buildId, setBuildId] = useState('');
+  const [pushtag, setPushtag] = useState('');
+  const [elfFile, setElfFile] = useState(null);
+  const [failOnMismatch, setFailOnMismatch] = useState(false);
   const [status, setStatus] = useState({ message: '', type: 'info' });
   const [isLoading, setIsLoading] = useState(false);
 
@@ -17,27 +42,40 @@ const LogDecoder = ({ userId, token }) => {
     try {
       const form = new FormData();
       form.append('file', file);
+      form.append('format', format);
+      if (tag.trim()) form.append('tag', tag.trim());
+      if (format === 'NVLOG' && bufferIndex !== '') form.append('bufferIndex', bufferIndex);
+      if (reverse) form.append('reverse', 'true');
+      form.append('output', output);
+      if (buildId.trim()) form.append('buildId', buildId.trim());
+      if (pushtag.trim()) form.append('pushtag', pushtag.trim());
+      if (elfFile) form.append('elf', elfFile);
+      if (elfFile || buildId.trim() || pushtag.trim()) form.append('elfMismatch', failOnMismatch ? 'fail' : 'warn');
       const res = await fetch(API_DECODE_URL, { method: 'POST', headers: { ...authHeader(token) }, body: form });
       if (!res.ok) {
         const text = await res.text();
         throw new Error(text || `Decode failed with ${res.status}`);
       }
+      const fileName = OUTPUT_FORMATS.find(f => f.value === output).fileName;
       const blob = await res.blob();
       const url = window.URL.createObjectURL(blob);
       const a = document.createElement('a');
       a.href = url;
-      a.download = 'dce-decoded.log';
+      a.download = fileName;
       document.body.appendChild(a);
       a.click();
       a.remove();
       window.URL.revokeObjectURL(url);
-      setStatus({ message: 'Decoded file downloaded as dce-decoded.log', type: 'success' });
+      const warning = res.headers.get('X-Decode-Warning');
+      setStatus(warning
+        ? { message: `Decoded file downloaded as ${fileName} (warning: ${warning})`, type: 'info' }
+        : { message: `Decoded file downloaded as ${fileName}`, type: 'success' });
     } catch (err) {
       setStatus({ message: `Decode error: ${err.message}`, type: 'error' });
     } finally {
       setIsLoading(false);
     }
-  }, [file, token]);
+  }, [file, format, tag, bufferIndex, reverse, output, buildId, pushtag, elfFile, failOnMismatch, token]);
 
   return (
     <div className="w-full max-w-2xl bg-white/80 backdrop-blur p-8 rounded-2xl shadow-xl border border-white/60 mx-auto">
@@ -57,6 +95,62 @@ const LogDecoder = ({ userId, token }) => {
                        hover:file:bg-indigo-100"
           />
         </div>
+        <div className="grid grid-cols-1 md:grid-cols-12 gap-3">
+          <div className="md:col-span-5">
+            <label className="block text-sm text-gray-700 mb-1">Format</label>
+            <select value={format} onChange={e => setFormat(e.target.value)} className="w-full px-3 py-2 border rounded-lg text-sm">
+              {DECODE_FORMATS.map(f => <option key={f.value} value={f.value}>{f.label}</option>)}
+            </select>
+          </div>
+          <div className="md:col-span-3">
+            <label className="block text-sm text-gray-700 mb-1">Tag (optional)</label>
+            <input type="text" value={tag} onChange={e => setTag(e.target.value)} className="w-full px-3 py-2 border rounded-lg text-sm" />
+          </div>
+          <div className="md:col-span-4">
+            <label className="block text-sm text-gray-700 mb-1">Buffer index (nvlog dump)</label>
+            <input type="number" min="0" value={bufferIndex} disabled={format !== 'NVLOG'} onChange={e => setBufferIndex(e.target.value)} className="w-full px-3 py-2 border rounded-lg text-sm disabled:bg-gray-100" />
+          </div>
+        </div>
+        <div className="grid grid-cols-1 md:grid-cols-2 gap-3">
+          <div>
+            <label className="block text-sm text-gray-700 mb-1">Build ID or prefix (optional)</label>
+            <input type="text" value={buildId} placeholder="from the log header" onChange={e => setBuildId(e.target.value)} className="w-full px-3 py-2 border rounded-lg text-sm font-mono" />
+          </div>
+          <div>
+            <label className="block text-sm text-gray-700 mb-1">Pushtag (optional)</label>
+            <input type="text" value={pushtag} placeholder="auto" onChange={e => setPushtag(e.target.value)} className="w-full px-3 py-2 border rounded-lg text-sm" />
+          </div>
+        </div>
+        <div>
+          <label className="block text-sm text-gray-700 mb-1">ELF for this decode only (optional, not stored)</label>
+          <input
+            type="file"
+            onChange={e => setElfFile(e.target.files?.[0] || null)}
+            className="block w-full text-sm text-gray-700
+                       file:mr-4 file:py-2 file:px-4
+                       file:rounded-lg file:border-0
+                       file:bg-indigo-50 file:text-indigo-700
+                       hover:file:bg-indigo-100"
+          />
+          {(elfFile || buildId.trim() || pushtag.trim()) && (
+            <label className="mt-2 flex items-center gap-2 text-sm text-gray-700">
+              <input type="checkbox" checked={failOnMismatch} onChange={e => setFailOnMismatch(e.target.checked)} />
+              Fail if the ELF's Build ID does not match the log
+            </label>
+          )}
+        </div>
+        <div className="flex flex-wrap items-center gap-x-6 gap-y-3">
+          <label className="flex items-center gap-2 text-sm text-gray-700">
+            <input type="checkbox" checked={reverse} onChange={e => setReverse(e.target.checked)} />
+            Reverse output (newest entries first)
+          </label>
+          <label className="flex items-center gap-2 text-sm text-gray-700">
+            Download as
+            <select value={output} onChange={e => setOutput(e.target.value)} className="px-3 py-1.5 border rounded-lg text-sm">
+              {OUTPUT_FORMATS.map(f => <option key={f.value} value={f.value}>{f.label}</option>)}
+            </select>
+          </label>
+        </div>
         <button
           type="submit"
           disabled={isLoading}
@@ -1,11 +1,20 @@
-import React, { useState, useCallback } from 'react';
-import { API_LOGIN_URL, StatusMessage } from './Constants.jsx'; 
+import React, { useState, useCallback, useEffect } from 'react';
+import { API_LOGIN_URL, API_OIDC_CONFIG_URL, StatusMessage } from './Constants.jsx'; 
 
 const LoginPage = ({ onLoginSuccess }) => {
     const [username, setUsername] = useState('');
     const [password, setPassword] = useState('');
     const [status, setStatus] = useState({ message: '', type: 'info' });
     const [isLoading, setIsLoading] = useState(false);
+    const [ssoLoginUrl, setSsoLoginUrl] = useState('');
+
+    // Offer SSO login when the backend has an OIDC provider configured
+    useEffect(() => {
+        fetch(API_OIDC_CONFIG_URL)
+            .then(res => res.json())
+            .then(cfg => { if (cfg?.enabled) setSsoLoginUrl(cfg.loginUrl); })
+            .catch(() => {});
+    }, []);
 
     const handleLogin = useCallback(async (e) => {
         e.preventDefault();
@@ -31,7 +40,7 @@ const LoginPage = ({ onLoginSuccess }) => {
                 const role = result.role || 'user'; 
                 const token = result.token || '';
                 setStatus({ message: `Login successful! Welcome, ${username} (${role}).`, type: 'success' });
-                onLoginSuccess(role, token)
+                onLoginSuccess(role, token, result.refreshToken || '', result.expiresIn || 0, result.permissions || [], result.mustChangePassword)
             } else {
                 setStatus({ message: result.message || "Login failed. Invalid credentials or server error.", type: 'error' });
             }
@@ -68,6 +77,12 @@ const LoginPage = ({ onLoginSuccess }) => {
                         {isLoading ? 'Verifying...' : 'Login'}
                     </button>
                 </form>
+                {ssoLoginUrl && (
+                    <a href={ssoLoginUrl}
+                        className="mt-4 block w-full py-2.5 px-4 rounded-xl text-center text-base font-semibold text-indigo-700 border border-indigo-300 bg-white hover:bg-indigo-50 transition duration-150 ease-in-out">
+                        Sign in with SSO
+                    </a>
+                )}
                 <StatusMessage message={status.message} type={status.type} />
             </div>
         </div>
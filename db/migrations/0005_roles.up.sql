@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS roles (
+  name VARCHAR(32) PRIMARY KEY,
+  description VARCHAR(255) NOT NULL DEFAULT ''
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+  role VARCHAR(32) NOT NULL,
+  permission VARCHAR(64) NOT NULL,
+  PRIMARY KEY (role, permission)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
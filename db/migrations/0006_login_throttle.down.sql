@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS login_history;
+DROP TABLE IF EXISTS login_throttle;
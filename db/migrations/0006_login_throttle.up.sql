@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS login_throttle (
+  kind VARCHAR(8) NOT NULL,
+  key_value VARCHAR(255) NOT NULL,
+  failures INT NOT NULL DEFAULT 0,
+  last_failure_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  locked_until TIMESTAMP NULL DEFAULT NULL,
+  PRIMARY KEY (kind, key_value)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS login_history (
+  id BIGINT AUTO_INCREMENT PRIMARY KEY,
+  username VARCHAR(255) NOT NULL,
+  client_ip VARCHAR(64) NOT NULL,
+  user_agent VARCHAR(255) NOT NULL DEFAULT '',
+  result VARCHAR(32) NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  INDEX idx_login_history_username (username),
+  INDEX idx_login_history_created (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+  id BIGINT AUTO_INCREMENT PRIMARY KEY,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  actor VARCHAR(255) NOT NULL DEFAULT '',
+  action VARCHAR(64) NOT NULL,
+  target VARCHAR(255) NOT NULL DEFAULT '',
+  client_ip VARCHAR(64) NOT NULL DEFAULT '',
+  result VARCHAR(16) NOT NULL,
+  status INT NOT NULL,
+  INDEX idx_audit_events_created (created_at),
+  INDEX idx_audit_events_actor (actor),
+  INDEX idx_audit_events_action (action)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only';
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only';
+
+-- The seeded auditor role reads the new audit log
+INSERT IGNORE INTO role_permissions (role, permission) VALUES ('auditor', 'audit:read');
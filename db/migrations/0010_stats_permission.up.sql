@@ -0,0 +1,2 @@
+-- stats:read guards the decoder pool statistics; the seeded auditor role reads them
+INSERT IGNORE INTO role_permissions (role, permission) VALUES ('auditor', 'stats:read');